@@ -2,15 +2,19 @@ module api-gateway
 
 go 1.25.5
 
-require github.com/5rfy/micro-delivery v0.0.0-20260214102013-1638356dc60c
+require (
+	github.com/5rfy/micro-delivery v0.0.0-20260214102013-1638356dc60c
+	github.com/gorilla/mux v1.8.1
+	github.com/shopspring/decimal v1.4.0
+	google.golang.org/grpc v1.79.1
+)
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/shopspring/decimal v1.4.0 // indirect
 	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/text v0.32.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251202230838-ff82c1b0f217 // indirect
-	google.golang.org/grpc v1.79.1 // indirect
-	google.golang.org/protobuf v1.36.10 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
 )
+
+replace github.com/5rfy/micro-delivery => ../
@@ -4,9 +4,13 @@ import (
 	"api-gateway/structs"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	deliverypb "github.com/5rfy/micro-delivery/proto/generated/delivery"
@@ -15,7 +19,9 @@ import (
 
 	"github.com/gorilla/mux"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 type Gateway struct {
@@ -32,17 +38,22 @@ func (g *Gateway) CreateOrder(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	if req.UserID == "" || len(req.Items) == 0 {
-		respondError(writer, http.StatusBadRequest, "UserId and Items are required")
+	if req.UserID == "" || len(req.Items) == 0 || strings.TrimSpace(req.DeliveryAddress) == "" {
+		respondError(writer, http.StatusBadRequest, "UserId, Items and DeliveryAddress are required")
 		return
 	}
 
 	pbItems := make([]*orderpb.OrderItem, len(req.Items))
 	for i, item := range req.Items {
+		if item.ProductId == "" || item.Quantity <= 0 {
+			respondError(writer, http.StatusBadRequest, "Each item needs productId (or product_id) and a positive quantity")
+			return
+		}
 		pbItems[i] = &orderpb.OrderItem{
 			ProductId: item.ProductId,
 			Quantity:  item.Quantity,
 			Price:     item.Price.InexactFloat64(),
+			Currency:  item.Currency,
 		}
 	}
 
@@ -53,10 +64,14 @@ func (g *Gateway) CreateOrder(writer http.ResponseWriter, request *http.Request)
 		UserId:          req.UserID,
 		Items:           pbItems,
 		DeliveryAddress: req.DeliveryAddress,
+		RecipientName:   req.RecipientName,
+		RecipientPhone:  req.RecipientPhone,
+		PaymentMethod:   req.PaymentMethod,
+		Currency:        req.Currency,
 	})
 	if err != nil {
 		log.Printf("CreateOrder error: %v", err)
-		respondError(writer, http.StatusInternalServerError, err.Error())
+		respondGrpcError(writer, err)
 		return
 	}
 	respondJson(writer, http.StatusOK, order)
@@ -82,6 +97,146 @@ func (g *Gateway) GetOrder(writer http.ResponseWriter, request *http.Request) {
 	respondJson(writer, http.StatusOK, order)
 }
 
+// POST /api/orders/{id}/cancel
+func (g *Gateway) CancelOrder(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	orderID := vars["id"]
+
+	var req structs.CancelOrder
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := g.orderClient.CancelOrder(ctx, &orderpb.CancelOrderRequest{
+		OrderId: orderID,
+		Reason:  req.Reason,
+	})
+	if err != nil {
+		log.Printf("CancelOrder error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, resp)
+}
+
+// GET /api/orders/{id}/history
+func (g *Gateway) GetOrderHistory(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	orderID := vars["id"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	history, err := g.orderClient.GetOrderHistory(ctx, &orderpb.GetOrderHistoryRequest{
+		OrderId: orderID,
+	})
+	if err != nil {
+		log.Printf("GetOrderHistory error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, history)
+}
+
+// GET /api/users/{userId}/orders?status=PAID,PENDING&from=...&to=...&page_size=20&cursor=...
+func (g *Gateway) ListOrders(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	query := request.URL.Query()
+
+	req := &orderpb.ListOrdersRequest{
+		UserId:      vars["userId"],
+		CreatedFrom: query.Get("from"),
+		CreatedTo:   query.Get("to"),
+		Cursor:      query.Get("cursor"),
+	}
+
+	for _, value := range query["status"] {
+		for _, st := range strings.Split(value, ",") {
+			if st = strings.TrimSpace(st); st != "" {
+				req.Statuses = append(req.Statuses, strings.ToUpper(st))
+			}
+		}
+	}
+
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size <= 0 {
+			respondError(writer, http.StatusBadRequest, "page_size must be a positive integer")
+			return
+		}
+		req.PageSize = int32(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	orders, err := g.orderClient.ListOrders(ctx, req)
+	if err != nil {
+		log.Printf("ListOrders error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, orders)
+}
+
+// GET /api/orders/{id}/saga
+func (g *Gateway) GetSaga(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	orderID := vars["id"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	saga, err := g.orderClient.GetSaga(ctx, &orderpb.GetSagaRequest{
+		OrderId: orderID,
+	})
+	if err != nil {
+		log.Printf("GetSaga error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, saga)
+}
+
+// GET /api/sagas/stuck?overdue_seconds=&limit=
+func (g *Gateway) ListStuckSagas(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	req := &orderpb.ListStuckSagasRequest{}
+
+	if overdue := query.Get("overdue_seconds"); overdue != "" {
+		seconds, err := strconv.Atoi(overdue)
+		if err != nil || seconds < 0 {
+			respondError(writer, http.StatusBadRequest, "overdue_seconds must be a non-negative integer")
+			return
+		}
+		req.OverdueSeconds = int32(seconds)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		size, err := strconv.Atoi(limit)
+		if err != nil || size <= 0 {
+			respondError(writer, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		req.Limit = int32(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sagas, err := g.orderClient.ListStuckSagas(ctx, req)
+	if err != nil {
+		log.Printf("ListStuckSagas error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, sagas)
+}
+
 // GET /api/orders/{id}/delivery
 func (g *Gateway) GetDeliveryStatus(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
@@ -103,6 +258,94 @@ func (g *Gateway) GetDeliveryStatus(writer http.ResponseWriter, request *http.Re
 	respondJson(writer, http.StatusOK, order)
 }
 
+// GET /api/orders/{id}/delivery/history
+func (g *Gateway) GetTrackingHistory(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	orderID := vars["id"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	history, err := g.deliveryClient.GetTrackingHistory(ctx, &deliverypb.GetTrackingHistoryRequest{
+		OrderId: orderID,
+	})
+	if err != nil {
+		log.Printf("GetTrackingHistory error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, history)
+}
+
+// GET /api/track/{trackingNumber}
+func (g *Gateway) TrackParcel(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	trackingNumber := vars["trackingNumber"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	parcel, err := g.deliveryClient.TrackParcel(ctx, &deliverypb.TrackParcelRequest{
+		TrackingNumber: trackingNumber,
+	})
+	if err != nil {
+		log.Printf("TrackParcel error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, parcel)
+}
+
+// POST /api/couriers
+func (g *Gateway) RegisterCourier(writer http.ResponseWriter, request *http.Request) {
+	var req structs.Courier
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	courier, err := g.deliveryClient.RegisterCourier(ctx, &deliverypb.RegisterCourierRequest{
+		Name:     req.Name,
+		Phone:    req.Phone,
+		Zone:     req.Zone,
+		Capacity: req.Capacity,
+	})
+	if err != nil {
+		log.Printf("RegisterCourier error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, courier)
+}
+
+// PUT /api/couriers/{id}/shift
+func (g *Gateway) SetCourierShift(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	var req structs.Shift
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := g.deliveryClient.SetCourierShift(ctx, &deliverypb.SetCourierShiftRequest{
+		CourierId: vars["id"],
+		OnShift:   req.OnShift,
+	})
+	if err != nil {
+		log.Printf("SetCourierShift error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, resp)
+}
+
 // GET /api/orders/{id}/payment
 func (g *Gateway) GetPaymentStatus(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
@@ -116,13 +359,335 @@ func (g *Gateway) GetPaymentStatus(writer http.ResponseWriter, request *http.Req
 	})
 	if err != nil {
 		log.Printf("GetPaymentStatus error: %v", err)
-		respondError(writer, http.StatusInternalServerError, "payment status not found")
+		respondGrpcError(writer, err)
 		return
 	}
 
 	respondJson(writer, http.StatusOK, status)
 }
 
+// POST /api/payments/{id}/refund — an empty body or amount 0 refunds the rest of the payment
+func (g *Gateway) RefundPayment(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	paymentID := vars["id"]
+
+	var req structs.Refund
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Amount.IsNegative() {
+		respondError(writer, http.StatusBadRequest, "amount must not be negative")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := g.paymentClient.RefundPayment(ctx, &paymentpb.RefundPaymentRequest{
+		PaymentId: paymentID,
+		Amount:    req.Amount.InexactFloat64(),
+		Reason:    req.Reason,
+	})
+	if err != nil {
+		log.Printf("RefundPayment error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, resp)
+}
+
+// GET /api/payments/review?page_size=
+func (g *Gateway) ListPaymentsForReview(writer http.ResponseWriter, request *http.Request) {
+	req := &paymentpb.ListPaymentsForReviewRequest{}
+	if pageSize := request.URL.Query().Get("page_size"); pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size <= 0 {
+			respondError(writer, http.StatusBadRequest, "page_size must be a positive integer")
+			return
+		}
+		req.PageSize = int32(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	payments, err := g.paymentClient.ListPaymentsForReview(ctx, req)
+	if err != nil {
+		log.Printf("ListPaymentsForReview error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, payments)
+}
+
+// POST /api/payments/{id}/review
+func (g *Gateway) ReviewPayment(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	var req structs.Review
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := g.paymentClient.ReviewPayment(ctx, &paymentpb.ReviewPaymentRequest{
+		PaymentId: vars["id"],
+		Decision:  req.Decision,
+		Reviewer:  req.Reviewer,
+		Note:      req.Note,
+	})
+	if err != nil {
+		log.Printf("ReviewPayment error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, resp)
+}
+
+// GET /api/users/{userId}/ledger?currency=&page_size=&cursor=
+func (g *Gateway) GetLedger(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	query := request.URL.Query()
+
+	req := &paymentpb.GetLedgerRequest{
+		UserId:   vars["userId"],
+		Currency: query.Get("currency"),
+		Cursor:   query.Get("cursor"),
+	}
+
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size <= 0 {
+			respondError(writer, http.StatusBadRequest, "page_size must be a positive integer")
+			return
+		}
+		req.PageSize = int32(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ledger, err := g.paymentClient.GetLedger(ctx, req)
+	if err != nil {
+		log.Printf("GetLedger error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, ledger)
+}
+
+// GET /api/users/{userId}/wallet?currency=
+func (g *Gateway) GetBalance(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	balance, err := g.paymentClient.GetBalance(ctx, &paymentpb.GetBalanceRequest{
+		UserId:   vars["userId"],
+		Currency: request.URL.Query().Get("currency"),
+	})
+	if err != nil {
+		log.Printf("GetBalance error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, balance)
+}
+
+// POST /api/users/{userId}/wallet/deposit
+func (g *Gateway) Deposit(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	var req structs.Deposit
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if !req.Amount.IsPositive() {
+		respondError(writer, http.StatusBadRequest, "amount must be positive")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := g.paymentClient.Deposit(ctx, &paymentpb.DepositRequest{
+		UserId:      vars["userId"],
+		Amount:      req.Amount.InexactFloat64(),
+		Currency:    req.Currency,
+		Description: req.Description,
+	})
+	if err != nil {
+		log.Printf("Deposit error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, resp)
+}
+
+// GET /api/users/{userId}/wallet/transactions?currency=EUR&type=PAYMENT,DEPOSIT&page_size=20&cursor=...
+func (g *Gateway) ListTransactions(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	query := request.URL.Query()
+
+	req := &paymentpb.ListTransactionsRequest{
+		UserId:   vars["userId"],
+		Currency: query.Get("currency"),
+		Cursor:   query.Get("cursor"),
+	}
+
+	for _, value := range query["type"] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				req.Types = append(req.Types, strings.ToUpper(t))
+			}
+		}
+	}
+
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size <= 0 {
+			respondError(writer, http.StatusBadRequest, "page_size must be a positive integer")
+			return
+		}
+		req.PageSize = int32(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	transactions, err := g.paymentClient.ListTransactions(ctx, req)
+	if err != nil {
+		log.Printf("ListTransactions error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, transactions)
+}
+
+// GET /api/users/{userId}/limits
+func (g *Gateway) GetSpendingLimits(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	limits, err := g.paymentClient.GetSpendingLimits(ctx, &paymentpb.GetSpendingLimitsRequest{
+		UserId: vars["userId"],
+	})
+	if err != nil {
+		log.Printf("GetSpendingLimits error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, limits)
+}
+
+// PUT /api/users/{userId}/limits
+func (g *Gateway) SetSpendingLimits(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	var req structs.SpendingLimits
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.PerOrderLimit.IsNegative() || req.DailyLimit.IsNegative() {
+		respondError(writer, http.StatusBadRequest, "limits must not be negative")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	limits, err := g.paymentClient.SetSpendingLimits(ctx, &paymentpb.SetSpendingLimitsRequest{
+		UserId:        vars["userId"],
+		PerOrderLimit: req.PerOrderLimit.InexactFloat64(),
+		DailyLimit:    req.DailyLimit.InexactFloat64(),
+	})
+	if err != nil {
+		log.Printf("SetSpendingLimits error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, limits)
+}
+
+// GET /api/fx/rates
+func (g *Gateway) ListFxRates(writer http.ResponseWriter, request *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rates, err := g.paymentClient.ListFxRates(ctx, &paymentpb.ListFxRatesRequest{})
+	if err != nil {
+		log.Printf("ListFxRates error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, rates)
+}
+
+// PUT /api/fx/rates/{base}/{quote}
+func (g *Gateway) SetFxRate(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	var req structs.FxRate
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		respondError(writer, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if !req.Rate.IsPositive() {
+		respondError(writer, http.StatusBadRequest, "rate must be positive")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rate, err := g.paymentClient.SetFxRate(ctx, &paymentpb.SetFxRateRequest{
+		Base:  vars["base"],
+		Quote: vars["quote"],
+		Rate:  req.Rate.InexactFloat64(),
+	})
+	if err != nil {
+		log.Printf("SetFxRate error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, rate)
+}
+
+// GET /api/fx/convert?amount=100&from=USD&to=EUR
+func (g *Gateway) Convert(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		respondError(writer, http.StatusBadRequest, "amount must be a positive number")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := g.paymentClient.Convert(ctx, &paymentpb.ConvertRequest{
+		Amount: amount,
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+	})
+	if err != nil {
+		log.Printf("Convert error: %v", err)
+		respondGrpcError(writer, err)
+		return
+	}
+	respondJson(writer, http.StatusOK, resp)
+}
+
 func respondJson(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -135,6 +700,21 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respondJson(w, status, map[string]string{"error": message})
 }
 
+// respondGrpcError maps the gRPC status of a failed call to an HTTP status.
+func respondGrpcError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.NotFound:
+		respondError(w, http.StatusNotFound, st.Message())
+	case codes.InvalidArgument:
+		respondError(w, http.StatusBadRequest, st.Message())
+	case codes.FailedPrecondition, codes.AlreadyExists:
+		respondError(w, http.StatusConflict, st.Message())
+	default:
+		respondError(w, http.StatusInternalServerError, st.Message())
+	}
+}
+
 func connectGrpc(addr string) *grpc.ClientConn {
 	for i := range 10 {
 		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -187,8 +767,29 @@ func main() {
 	router.HandleFunc("/health", healthCheck).Methods("GET")
 	router.HandleFunc("/api/orders", gw.CreateOrder).Methods("POST")
 	router.HandleFunc("/api/orders/{id}", gw.GetOrder).Methods("GET")
+	router.HandleFunc("/api/orders/{id}/cancel", gw.CancelOrder).Methods("POST")
+	router.HandleFunc("/api/orders/{id}/history", gw.GetOrderHistory).Methods("GET")
+	router.HandleFunc("/api/users/{userId}/orders", gw.ListOrders).Methods("GET")
+	router.HandleFunc("/api/orders/{id}/saga", gw.GetSaga).Methods("GET")
+	router.HandleFunc("/api/sagas/stuck", gw.ListStuckSagas).Methods("GET")
 	router.HandleFunc("/api/orders/{id}/delivery", gw.GetDeliveryStatus).Methods("GET")
+	router.HandleFunc("/api/orders/{id}/delivery/history", gw.GetTrackingHistory).Methods("GET")
+	router.HandleFunc("/api/track/{trackingNumber}", gw.TrackParcel).Methods("GET")
+	router.HandleFunc("/api/couriers", gw.RegisterCourier).Methods("POST")
+	router.HandleFunc("/api/couriers/{id}/shift", gw.SetCourierShift).Methods("PUT")
 	router.HandleFunc("/api/orders/{id}/payment", gw.GetPaymentStatus).Methods("GET")
+	router.HandleFunc("/api/payments/{id}/refund", gw.RefundPayment).Methods("POST")
+	router.HandleFunc("/api/payments/review", gw.ListPaymentsForReview).Methods("GET")
+	router.HandleFunc("/api/payments/{id}/review", gw.ReviewPayment).Methods("POST")
+	router.HandleFunc("/api/users/{userId}/ledger", gw.GetLedger).Methods("GET")
+	router.HandleFunc("/api/users/{userId}/wallet", gw.GetBalance).Methods("GET")
+	router.HandleFunc("/api/users/{userId}/wallet/deposit", gw.Deposit).Methods("POST")
+	router.HandleFunc("/api/users/{userId}/wallet/transactions", gw.ListTransactions).Methods("GET")
+	router.HandleFunc("/api/users/{userId}/limits", gw.GetSpendingLimits).Methods("GET")
+	router.HandleFunc("/api/users/{userId}/limits", gw.SetSpendingLimits).Methods("PUT")
+	router.HandleFunc("/api/fx/rates", gw.ListFxRates).Methods("GET")
+	router.HandleFunc("/api/fx/rates/{base}/{quote}", gw.SetFxRate).Methods("PUT")
+	router.HandleFunc("/api/fx/convert", gw.Convert).Methods("GET")
 
 	port := os.Getenv("HTTP_PORT")
 	if port == "" {
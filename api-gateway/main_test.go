@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	orderpb "github.com/5rfy/micro-delivery/proto/generated/order"
+	"google.golang.org/grpc"
+)
+
+type fakeOrderClient struct {
+	orderpb.OrderServiceClient
+	created *orderpb.CreateOrderRequest
+}
+
+func (c *fakeOrderClient) CreateOrder(_ context.Context, req *orderpb.CreateOrderRequest, _ ...grpc.CallOption) (*orderpb.CreateOrderResponse, error) {
+	c.created = req
+	return &orderpb.CreateOrderResponse{OrderId: "order-1"}, nil
+}
+
+// createDeliveryCommand is the command the saga sends for the order placed
+// below. The order-service tests check the saga sends it and the
+// delivery-service tests that its address is the one stored for the delivery.
+const createDeliveryCommand = "../testdata/create_delivery_command.json"
+
+func TestCreateOrderForwardsTheDeliveryAddress(t *testing.T) {
+	fixture, err := os.ReadFile(createDeliveryCommand)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var want struct {
+		UserId          string `json:"user_id"`
+		DeliveryAddress string `json:"delivery_address"`
+		RecipientName   string `json:"recipient_name"`
+		RecipientPhone  string `json:"recipient_phone"`
+	}
+	if err := json.Unmarshal(fixture, &want); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+
+	orders := &fakeOrderClient{}
+	gw := &Gateway{orderClient: orders}
+
+	body := fmt.Sprintf(`{"user_id": %q, "delivery_address": %q, "recipient_name": %q, "recipient_phone": %q,
+		"items": [{"productId": "prod-1", "quantity": 1, "price": 10}]}`,
+		want.UserId, want.DeliveryAddress, want.RecipientName, want.RecipientPhone)
+	recorder := httptest.NewRecorder()
+	gw.CreateOrder(recorder, httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	if orders.created == nil {
+		t.Fatal("CreateOrder was not called")
+	}
+	if got := orders.created.DeliveryAddress; got != want.DeliveryAddress {
+		t.Fatalf("delivery address = %q, want %q", got, want.DeliveryAddress)
+	}
+	if got := orders.created.RecipientName; got != want.RecipientName {
+		t.Fatalf("recipient name = %q, want %q", got, want.RecipientName)
+	}
+	if got := orders.created.RecipientPhone; got != want.RecipientPhone {
+		t.Fatalf("recipient phone = %q, want %q", got, want.RecipientPhone)
+	}
+}
+
+func TestCreateOrderRequiresADeliveryAddress(t *testing.T) {
+	orders := &fakeOrderClient{}
+	gw := &Gateway{orderClient: orders}
+
+	body := `{"user_id": "user-1", "delivery_address": " ", "items": [{"productId": "prod-1", "quantity": 1}]}`
+	recorder := httptest.NewRecorder()
+	gw.CreateOrder(recorder, httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	if orders.created != nil {
+		t.Fatal("CreateOrder was called")
+	}
+}
@@ -1,15 +1,84 @@
 package structs
 
-import "github.com/shopspring/decimal"
+import (
+	"encoding/json"
+
+	"github.com/shopspring/decimal"
+)
 
 type Item struct {
 	ProductId string          `json:"productId"`
 	Quantity  int32           `json:"quantity"`
 	Price     decimal.Decimal `json:"price"`
+	Currency  string          `json:"currency"`
+}
+
+// UnmarshalJSON reads the product id from productId, as existing clients send
+// it, or from product_id, the spelling the rest of the API uses.
+func (i *Item) UnmarshalJSON(data []byte) error {
+	type item Item
+	var v struct {
+		item
+		SnakeProductId string `json:"product_id"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*i = Item(v.item)
+	if i.ProductId == "" {
+		i.ProductId = v.SnakeProductId
+	}
+	return nil
 }
 
 type Order struct {
 	Items           []Item `json:"items"`
 	UserID          string `json:"user_id"`
 	DeliveryAddress string `json:"delivery_address"`
+	RecipientName   string `json:"recipient_name"`
+	RecipientPhone  string `json:"recipient_phone"`
+	PaymentMethod   string `json:"payment_method"`
+	Currency        string `json:"currency"`
+}
+
+type CancelOrder struct {
+	Reason string `json:"reason"`
+}
+
+type Deposit struct {
+	Amount      decimal.Decimal `json:"amount"`
+	Currency    string          `json:"currency"`
+	Description string          `json:"description"`
+}
+
+type Review struct {
+	Decision string `json:"decision"`
+	Reviewer string `json:"reviewer"`
+	Note     string `json:"note"`
+}
+
+type SpendingLimits struct {
+	PerOrderLimit decimal.Decimal `json:"per_order_limit"`
+	DailyLimit    decimal.Decimal `json:"daily_limit"`
+}
+
+type FxRate struct {
+	Rate decimal.Decimal `json:"rate"`
+}
+
+type Courier struct {
+	Name     string `json:"name"`
+	Phone    string `json:"phone"`
+	Zone     string `json:"zone"`
+	Capacity int32  `json:"capacity"`
+}
+
+type Shift struct {
+	OnShift bool `json:"on_shift"`
+}
+
+type Refund struct {
+	Amount decimal.Decimal `json:"amount"`
+	Reason string          `json:"reason"`
 }
@@ -0,0 +1,298 @@
+// Package courier assigns deliveries to couriers. Every courier works in one
+// zone, and a delivery belongs to the zone named in its address. A delivery
+// goes to the on-shift courier in the zone nearest to its own who still has
+// capacity, and among couriers equally near to the one carrying the fewest
+// parcels.
+package courier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Assignment reasons.
+const (
+	ReasonNewDelivery     = "NEW_DELIVERY"
+	ReasonCourierOffShift = "COURIER_OFF_SHIFT"
+	ReasonCourierOnShift  = "COURIER_ON_SHIFT"
+	ReasonCourierFreed    = "COURIER_FREED"
+)
+
+// activeStatuses are the delivery statuses that count towards a courier's
+// load.
+const activeStatuses = `('PENDING', 'IN_TRANSIT', 'OUT_FOR_DELIVERY')`
+
+var ErrNoCourier = errors.New("no courier available")
+
+// Zone is an area couriers work in, located by its centre.
+type Zone struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+var DefaultZones = []Zone{
+	{"Москва", 55.7558, 37.6173},
+	{"Санкт-Петербург", 59.9343, 30.3351},
+	{"Казань", 55.7963, 49.1088},
+	{"Berlin", 52.5200, 13.4050},
+}
+
+type Assigner struct {
+	zones map[string]Zone
+	// names are the lower-cased zone names, longest first, so an address
+	// naming a zone inside a longer one resolves to the longer one.
+	names []string
+}
+
+func NewAssigner(zones []Zone) *Assigner {
+	a := &Assigner{zones: map[string]Zone{}}
+	for _, zone := range zones {
+		name := strings.ToLower(zone.Name)
+		a.zones[name] = zone
+		a.names = append(a.names, name)
+	}
+	sort.Slice(a.names, func(i, j int) bool { return len(a.names[i]) > len(a.names[j]) })
+	return a
+}
+
+// Zone returns the zone with a name, ignoring case.
+func (a *Assigner) Zone(name string) (Zone, bool) {
+	zone, ok := a.zones[strings.ToLower(strings.TrimSpace(name))]
+	return zone, ok
+}
+
+// ZoneOf returns the name of the zone an address lies in, or "" if it names
+// none.
+func (a *Assigner) ZoneOf(address string) string {
+	address = strings.ToLower(address)
+	for _, name := range a.names {
+		if strings.Contains(address, name) {
+			return a.zones[name].Name
+		}
+	}
+	return ""
+}
+
+// Lock serializes assignments until tx ends, so couriers' loads read inside
+// tx stay current and no courier is given more parcels than its capacity.
+func Lock(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('courier_assignment'))`); err != nil {
+		return fmt.Errorf("failed to lock courier assignment: %w", err)
+	}
+	return nil
+}
+
+type candidate struct {
+	id       string
+	zone     string
+	load     int
+	distance float64
+}
+
+// rank orders the couriers that could take a delivery in target, best
+// first: the nearest zone, then the fewest parcels, then the id, so the
+// choice does not depend on the order the couriers were read in. Couriers
+// of zones no longer configured come last.
+func (a *Assigner) rank(target Zone, candidates []candidate) {
+	for i := range candidates {
+		candidates[i].distance = math.Inf(1)
+		if z, ok := a.Zone(candidates[i].zone); ok {
+			candidates[i].distance = distance(target, z)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		if candidates[i].load != candidates[j].load {
+			return candidates[i].load < candidates[j].load
+		}
+		return candidates[i].id < candidates[j].id
+	})
+}
+
+// Assign picks a courier for a delivery in zone and records the assignment
+// inside tx. It fails with ErrNoCourier if every on-shift courier is at
+// capacity.
+func (a *Assigner) Assign(ctx context.Context, tx *sql.Tx, deliveryId string, zone string, reason string) (string, error) {
+	if err := Lock(ctx, tx); err != nil {
+		return "", err
+	}
+
+	target, ok := a.Zone(zone)
+	if !ok {
+		return "", fmt.Errorf("%w: unknown zone %q", ErrNoCourier, zone)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT c.id, c.zone, COUNT(d.id) FROM couriers c
+		 LEFT JOIN courier_assignments s ON s.courier_id = c.id AND s.released_at IS NULL
+		 LEFT JOIN deliveries d ON d.id = s.delivery_id AND d.status IN `+activeStatuses+`
+		 WHERE c.on_shift
+		 GROUP BY c.id, c.zone, c.capacity
+		 HAVING COUNT(d.id) < c.capacity`)
+	if err != nil {
+		return "", fmt.Errorf("failed to select couriers: %w", err)
+	}
+
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.zone, &c.load); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("failed to scan courier: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to select couriers: %w", err)
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoCourier
+	}
+
+	a.rank(target, candidates)
+	courierId := candidates[0].id
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO courier_assignments (delivery_id, courier_id, reason, assigned_at) VALUES ($1, $2, $3, $4)`,
+		deliveryId, courierId, reason, time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to record courier assignment: %w", err)
+	}
+	return courierId, nil
+}
+
+// Reassign releases the deliveries a courier going off shift is carrying and
+// assigns them to other couriers inside tx. It returns how many deliveries
+// were reassigned and how many are left waiting for a courier.
+func (a *Assigner) Reassign(ctx context.Context, tx *sql.Tx, courierId string) (int, int, error) {
+	if err := Lock(ctx, tx); err != nil {
+		return 0, 0, err
+	}
+
+	waiting, err := deliveries(ctx, tx,
+		`SELECT d.id, COALESCE(d.zone, '') FROM courier_assignments s JOIN deliveries d ON d.id = s.delivery_id
+		 WHERE s.courier_id = $1 AND s.released_at IS NULL AND d.status IN `+activeStatuses+`
+		 ORDER BY d.created_at`,
+		courierId,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE courier_assignments SET released_at = $1 WHERE courier_id = $2 AND released_at IS NULL`,
+		time.Now(), courierId,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to release courier assignments: %w", err)
+	}
+
+	return a.assignAll(ctx, tx, waiting, ReasonCourierOffShift)
+}
+
+// AssignWaiting assigns the deliveries waiting for a courier inside tx, oldest
+// first, once a courier comes on shift. It returns how many deliveries were
+// assigned and how many are still waiting.
+func (a *Assigner) AssignWaiting(ctx context.Context, tx *sql.Tx) (int, int, error) {
+	return a.assignWaiting(ctx, tx, ReasonCourierOnShift)
+}
+
+// Freed assigns the deliveries waiting for a courier inside tx once the
+// delivery deliveryId, delivered or cancelled, no longer counts towards the
+// load of its courier. A delivery without a courier frees no capacity, and
+// nothing is assigned.
+func (a *Assigner) Freed(ctx context.Context, tx *sql.Tx, deliveryId string) (int, int, error) {
+	var assigned bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM courier_assignments WHERE delivery_id = $1 AND released_at IS NULL)`,
+		deliveryId,
+	).Scan(&assigned)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get courier assignment: %w", err)
+	}
+	if !assigned {
+		return 0, 0, nil
+	}
+	return a.assignWaiting(ctx, tx, ReasonCourierFreed)
+}
+
+func (a *Assigner) assignWaiting(ctx context.Context, tx *sql.Tx, reason string) (int, int, error) {
+	if err := Lock(ctx, tx); err != nil {
+		return 0, 0, err
+	}
+
+	waiting, err := deliveries(ctx, tx,
+		`SELECT d.id, d.zone FROM deliveries d
+		 WHERE d.status IN `+activeStatuses+` AND d.zone IS NOT NULL
+		   AND NOT EXISTS (SELECT 1 FROM courier_assignments s WHERE s.delivery_id = d.id AND s.released_at IS NULL)
+		 ORDER BY d.created_at`,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	return a.assignAll(ctx, tx, waiting, reason)
+}
+
+type delivery struct {
+	id   string
+	zone string
+}
+
+func (a *Assigner) assignAll(ctx context.Context, tx *sql.Tx, waiting []delivery, reason string) (int, int, error) {
+	assigned := 0
+	for _, d := range waiting {
+		_, err := a.Assign(ctx, tx, d.id, d.zone, reason)
+		if errors.Is(err, ErrNoCourier) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		assigned++
+	}
+	return assigned, len(waiting) - assigned, nil
+}
+
+func deliveries(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]delivery, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var result []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.zone); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery: %w", err)
+		}
+		result = append(result, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to select deliveries: %w", err)
+	}
+	return result, nil
+}
+
+// distance returns the great-circle distance between the centres of two
+// zones in kilometres.
+func distance(from Zone, to Zone) float64 {
+	const earthRadius = 6371.0
+	lat1 := from.Latitude * math.Pi / 180
+	lat2 := to.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (to.Longitude - from.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,85 @@
+package courier
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRankPrefersTheNearestZoneThenTheLightestLoad(t *testing.T) {
+	a := NewAssigner(DefaultZones)
+	moscow, _ := a.Zone("Москва")
+	kazan, _ := a.Zone("Казань")
+
+	tests := []struct {
+		name       string
+		target     Zone
+		candidates []candidate
+		want       []string
+	}{
+		{
+			name:   "own zone before a lighter courier elsewhere",
+			target: kazan,
+			candidates: []candidate{
+				{id: "moscow", zone: "Москва", load: 0},
+				{id: "kazan", zone: "Казань", load: 3},
+			},
+			want: []string{"kazan", "moscow"},
+		},
+		{
+			name:   "other zones by distance",
+			target: moscow,
+			candidates: []candidate{
+				{id: "berlin", zone: "Berlin"},
+				{id: "kazan", zone: "Казань"},
+				{id: "spb", zone: "Санкт-Петербург"},
+			},
+			want: []string{"spb", "kazan", "berlin"},
+		},
+		{
+			name:   "same zone by load, then by id",
+			target: moscow,
+			candidates: []candidate{
+				{id: "c", zone: "Москва", load: 1},
+				{id: "b", zone: "москва", load: 2},
+				{id: "a", zone: "Москва", load: 1},
+			},
+			want: []string{"a", "c", "b"},
+		},
+		{
+			name:   "unconfigured zones last",
+			target: moscow,
+			candidates: []candidate{
+				{id: "gone", zone: "Atlantis"},
+				{id: "berlin", zone: "Berlin", load: 5},
+			},
+			want: []string{"berlin", "gone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.rank(tt.target, tt.candidates)
+			var got []string
+			for _, c := range tt.candidates {
+				got = append(got, c.id)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("ranked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneOfPrefersTheLongerName(t *testing.T) {
+	a := NewAssigner([]Zone{{Name: "York"}, {Name: "New York"}})
+
+	tests := map[string]string{
+		"1 Main St, New York": "New York",
+		"2 High St, York":     "York",
+		"3 Nowhere Lane":      "",
+	}
+	for address, want := range tests {
+		if got := a.ZoneOf(address); got != want {
+			t.Errorf("ZoneOf(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
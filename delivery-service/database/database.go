@@ -33,6 +33,80 @@ func InitDb(url string) *sql.DB {
 			created_at TIMESTAMP DEFAULT NOW(),
 			updated_at TIMESTAMP DEFAULT NOW()
 		)`,
+		`ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS recipient_name VARCHAR(255)`,
+		`ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS recipient_phone VARCHAR(50)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_deliveries_tracking_number ON deliveries (tracking_number)`,
+		`ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS zone VARCHAR(100)`,
+		`CREATE TABLE IF NOT EXISTS couriers (
+			id UUID PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			phone VARCHAR(50),
+			zone VARCHAR(100) NOT NULL,
+			capacity INT NOT NULL CHECK (capacity > 0),
+			on_shift BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT NOW(),
+			updated_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS courier_assignments (
+			id BIGSERIAL PRIMARY KEY,
+			delivery_id UUID NOT NULL REFERENCES deliveries(id),
+			courier_id UUID NOT NULL REFERENCES couriers(id),
+			reason VARCHAR(50) NOT NULL,
+			assigned_at TIMESTAMP DEFAULT NOW(),
+			released_at TIMESTAMP
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_courier_assignments_delivery ON courier_assignments (delivery_id) WHERE released_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_courier_assignments_courier ON courier_assignments (courier_id) WHERE released_at IS NULL`,
+		`CREATE TABLE IF NOT EXISTS delivery_transitions (
+			id BIGSERIAL PRIMARY KEY,
+			delivery_id UUID NOT NULL REFERENCES deliveries(id),
+			order_id UUID NOT NULL,
+			status VARCHAR(50) NOT NULL,
+			location TEXT NOT NULL,
+			due_at TIMESTAMP NOT NULL,
+			applied_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_delivery_transitions_due ON delivery_transitions (due_at) WHERE applied_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_delivery_transitions_delivery ON delivery_transitions (delivery_id, id) WHERE applied_at IS NULL`,
+		`CREATE TABLE IF NOT EXISTS delivery_events (
+			id BIGSERIAL PRIMARY KEY,
+			delivery_id UUID NOT NULL REFERENCES deliveries(id),
+			status VARCHAR(50) NOT NULL,
+			location TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_delivery_events_delivery ON delivery_events (delivery_id, id)`,
+		`INSERT INTO delivery_events (delivery_id, status, location, created_at)
+		 SELECT id, status, COALESCE(current_location, ''), COALESCE(updated_at, created_at) FROM deliveries d
+		 WHERE NOT EXISTS (SELECT 1 FROM delivery_events e WHERE e.delivery_id = d.id)`,
+		`CREATE TABLE IF NOT EXISTS outbox_events (
+			id UUID PRIMARY KEY,
+			seq BIGSERIAL,
+			topic VARCHAR(255) NOT NULL,
+			event_key VARCHAR(255),
+			payload TEXT NOT NULL,
+			sent BOOLEAN DEFAULT FALSE,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP DEFAULT NOW(),
+			sent_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_events_unsent ON outbox_events (seq) WHERE sent = FALSE`,
+		`ALTER TABLE outbox_events
+			ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_events_sent ON outbox_events (sent_at) WHERE sent = TRUE`,
+		`CREATE TABLE IF NOT EXISTS processed_events (
+			event_id VARCHAR(255) PRIMARY KEY,
+			topic VARCHAR(255) NOT NULL,
+			processed_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS cancelled_orders (
+			order_id UUID PRIMARY KEY,
+			reason TEXT,
+			cancelled_at TIMESTAMP DEFAULT NOW()
+		)`,
 	}
 
 	for _, m := range migrations {
@@ -4,20 +4,31 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/inbox"
+	"github.com/5rfy/micro-delivery/pkg/retry"
 	"github.com/5rfy/micro-delivery/proto/generated/delivery"
 	"github.com/IBM/sarama"
 	"main.go/service"
 	"main.go/structs"
 )
 
-const TopicPaymentCompleted = "payment.completed"
+const TopicDeliveryCommands = "delivery.commands"
+
+const (
+	CommandCreateDelivery = "CREATE_DELIVERY"
+	CommandCancelDelivery = "CANCEL_DELIVERY"
+)
+
+const consumerGroup = "delivery-service-group"
 
 type ConsumerHandler struct {
-	db       *sql.DB
-	producer sarama.SyncProducer
+	db     *sql.DB
+	server *service.Server
+	retry  *retry.Retrier
 }
 
 func (h *ConsumerHandler) Setup(_ sarama.ConsumerGroupSession) error {
@@ -28,47 +39,92 @@ func (h *ConsumerHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
 }
 
 func (h *ConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		var event structs.PaymentCompletedEvent
-		if err := json.Unmarshal(msg.Value, &event); err != nil {
-			log.Printf("Failed to unmarshal payment event: %v", err)
-			session.MarkMessage(msg, "")
-			continue
-		}
+	return h.retry.Consume(session, claim, h.handle)
+}
+
+func (h *ConsumerHandler) handle(msg *sarama.ConsumerMessage) error {
+	switch retry.SourceTopic(msg) {
+	case TopicDeliveryCommands:
+		return h.handleCommand(msg)
+	}
+	return nil
+}
+
+// handleCommand executes a command sent by the order saga. Commands are
+// deduplicated by their command id, so a command re-sent after a timeout is
+// applied at most once.
+func (h *ConsumerHandler) handleCommand(msg *sarama.ConsumerMessage) error {
+	var command structs.DeliveryCommand
+	if err := json.Unmarshal(msg.Value, &command); err != nil {
+		return retry.Permanent(fmt.Errorf("failed to unmarshal command: %w", err))
+	}
 
-		if event.Status == "SUCCESS" {
-			log.Printf("Payment success for order %s — creating delivery", event.OrderId)
-			s := service.NewServer(h.db, h.producer)
-			_, err := s.CreateDelivery(context.Background(), &delivery.CreateDeliveryRequest{
-				OrderId:         event.OrderId,
-				UserId:          event.UserId,
-				DeliveryAddress: "Default Address",
+	id := command.CommandId
+	if id == "" {
+		id = inbox.EventId(msg)
+	}
+
+	ctx := context.Background()
+
+	var resp *delivery.CreateDeliveryResponse
+	processed, err := inbox.Handle(ctx, h.db, id, TopicDeliveryCommands, func(tx *sql.Tx) error {
+		switch command.Type {
+		case CommandCreateDelivery:
+			var err error
+			resp, err = h.server.CreateDeliveryTx(ctx, tx, &delivery.CreateDeliveryRequest{
+				OrderId:         command.OrderId,
+				UserId:          command.UserId,
+				DeliveryAddress: command.DeliveryAddress,
+				RecipientName:   command.RecipientName,
+				RecipientPhone:  command.RecipientPhone,
 			})
-			if err != nil {
-				log.Printf("Failed to create delivery: %v", err)
-			}
-		} else {
-			log.Printf("Payment failed for order %s (%s) — no delivery created", event.OrderId, event.Status)
+			return err
+		case CommandCancelDelivery:
+			return h.server.CancelDeliveryTx(ctx, tx, command.OrderId, command.Reason)
 		}
-		session.MarkMessage(msg, "")
+		return retry.Permanent(fmt.Errorf("unknown command type %q", command.Type))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute %s: %w", command.Type, err)
+	}
+	if !processed {
+		log.Printf("Skipping duplicate %s command for order %s", command.Type, command.OrderId)
+		return nil
+	}
+	if resp == nil {
+		return nil
+	}
+	if resp.Status == "CANCELLED" {
+		log.Printf("Order %s was cancelled — no delivery created", command.OrderId)
 	}
 	return nil
 }
 
-func StartConsumer(db *sql.DB, producer sarama.SyncProducer, brokers []string) {
+func StartConsumer(db *sql.DB, server *service.Server, producer sarama.SyncProducer, brokers []string) {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
 
-	group, err := sarama.NewConsumerGroup(brokers, "delivery-service-group", config)
+	group, err := sarama.NewConsumerGroup(brokers, consumerGroup, config)
 	if err != nil {
 		log.Fatalf("Failed to create consumer group: %v", err)
 	}
 
-	handler := &ConsumerHandler{db: db, producer: producer}
+	handler := &ConsumerHandler{
+		db:     db,
+		server: server,
+		retry:  retry.NewRetrier(producer, retry.DefaultPolicy),
+	}
+	topics := retry.DefaultPolicy.Topics(TopicDeliveryCommands)
+
 	for {
-		if err := group.Consume(context.Background(), []string{TopicPaymentCompleted}, handler); err != nil {
-			log.Fatalf("Error on consume from group: %v", err)
+		if err := group.Consume(context.Background(), topics, handler); err != nil {
+			log.Printf("Consumer error: %v", err)
 			time.Sleep(5 * time.Second)
 		}
 	}
 }
+
+// RedriveDLQ publishes everything parked on <topic>.dlq back to <topic>.
+func RedriveDLQ(brokers []string, topic string) error {
+	return retry.RedriveDLQ(brokers, consumerGroup, topic)
+}
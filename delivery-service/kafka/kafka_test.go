@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/retry"
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"main.go/courier"
+	"main.go/database"
+	"main.go/service"
+	"main.go/structs"
+)
+
+func newHandler(t *testing.T) *ConsumerHandler {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+	return &ConsumerHandler{db: db, server: service.NewServer(db, courier.NewAssigner(nil))}
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...any) int {
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	return n
+}
+
+// replays returns the ways one command reaches the consumer again: the same
+// message redelivered after a rebalance, the saga re-sending it after a
+// timeout, and a copy coming back from a retry topic.
+func replays(t *testing.T, command structs.DeliveryCommand) []*sarama.ConsumerMessage {
+	value, err := json.Marshal(command)
+	if err != nil {
+		t.Fatalf("failed to marshal command: %v", err)
+	}
+	return []*sarama.ConsumerMessage{
+		{Topic: TopicDeliveryCommands, Offset: 7, Value: value},
+		{Topic: TopicDeliveryCommands, Offset: 7, Value: value},
+		{Topic: TopicDeliveryCommands, Offset: 12, Value: value},
+		{Topic: TopicDeliveryCommands + ".retry.1", Offset: 3, Value: value, Headers: []*sarama.RecordHeader{
+			{Key: []byte(retry.HeaderOriginalTopic), Value: []byte(TopicDeliveryCommands)},
+		}},
+	}
+}
+
+func TestReplayedCreateCommandCreatesOneDelivery(t *testing.T) {
+	h := newHandler(t)
+	orderId := uuid.New().String()
+	command := structs.DeliveryCommand{
+		CommandId:       orderId + ":DELIVERY",
+		Type:            CommandCreateDelivery,
+		OrderId:         orderId,
+		UserId:          "user-1",
+		DeliveryAddress: "1 Main St",
+	}
+
+	for i, msg := range replays(t, command) {
+		if err := h.handle(msg); err != nil {
+			t.Fatalf("delivery %d: %v", i, err)
+		}
+	}
+
+	if n := count(t, h.db, `SELECT COUNT(*) FROM deliveries WHERE order_id = $1`, orderId); n != 1 {
+		t.Fatalf("deliveries = %d, want 1", n)
+	}
+	if n := count(t, h.db, `SELECT COUNT(*) FROM outbox_events WHERE topic = $1`, service.TopicDeliveryUpdated); n != 1 {
+		t.Fatalf("delivery.status.updated events = %d, want 1", n)
+	}
+}
+
+// The order-service tests check that an order placed through POST
+// /api/orders makes the saga send exactly this command.
+const createDeliveryCommand = "../../testdata/create_delivery_command.json"
+
+func TestCreateCommandStoresTheOrderAddress(t *testing.T) {
+	h := newHandler(t)
+	orderId := uuid.New().String()
+	fixture, err := os.ReadFile(createDeliveryCommand)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	value := []byte(strings.ReplaceAll(string(fixture), "ORDER_ID", orderId))
+	if err := h.handle(&sarama.ConsumerMessage{Topic: TopicDeliveryCommands, Value: value}); err != nil {
+		t.Fatalf("failed to handle command: %v", err)
+	}
+
+	var address, recipient, phone string
+	err = h.db.QueryRow(
+		`SELECT delivery_address, recipient_name, recipient_phone FROM deliveries WHERE order_id = $1`,
+		orderId,
+	).Scan(&address, &recipient, &phone)
+	if err != nil {
+		t.Fatalf("failed to get delivery: %v", err)
+	}
+	if want := "ul. Lenina 1, Moscow"; address != want {
+		t.Fatalf("delivery address = %q, want %q", address, want)
+	}
+	if recipient != "Ivan" || phone != "+7 900 000-00-00" {
+		t.Fatalf("recipient = %q, %q, want %q, %q", recipient, phone, "Ivan", "+7 900 000-00-00")
+	}
+}
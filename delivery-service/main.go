@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/5rfy/micro-delivery/pkg/outbox"
 	"github.com/5rfy/micro-delivery/proto/generated/delivery"
 	"github.com/IBM/sarama"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+	"main.go/courier"
 	"main.go/database"
 	"main.go/kafka"
 	"main.go/service"
@@ -25,6 +30,14 @@ func main() {
 		kafkaBrokers = []string{"localhost:9092"}
 	}
 
+	// redrive <topic> re-publishes <topic>.dlq to <topic> and exits.
+	if len(os.Args) == 3 && os.Args[1] == "redrive" {
+		if err := kafka.RedriveDLQ(kafkaBrokers, os.Args[2]); err != nil {
+			log.Fatalf("Failed to re-drive DLQ: %v", err)
+		}
+		return
+	}
+
 	db := database.InitDb(dsn)
 
 	config := sarama.NewConfig()
@@ -37,7 +50,16 @@ func main() {
 	}
 	defer producer.Close()
 
-	go kafka.StartConsumer(db, producer, kafkaBrokers)
+	if err := service.ResumeInFlightDeliveries(context.Background(), db); err != nil {
+		log.Fatalf("Failed to resume deliveries: %v", err)
+	}
+
+	couriers := courier.NewAssigner(courierZones())
+	server := service.NewServer(db, couriers)
+
+	go kafka.StartConsumer(db, server, producer, kafkaBrokers)
+	go outbox.NewRelay(db, producer).Run(context.Background())
+	go service.NewProgressWorker(db, couriers).Run(context.Background())
 
 	port := os.Getenv("GRPC_PORT")
 	if port == "" {
@@ -50,7 +72,7 @@ func main() {
 	}
 
 	grpcServer := grpc.NewServer()
-	delivery.RegisterDeliveryServiceServer(grpcServer, service.NewServer(db, producer))
+	delivery.RegisterDeliveryServiceServer(grpcServer, server)
 	reflection.Register(grpcServer)
 
 	log.Printf("Delivery Service gRPC listening on :%s", port)
@@ -58,3 +80,34 @@ func main() {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
+
+// courierZones reads the zones couriers work in from COURIER_ZONES, a
+// semicolon-separated list of name:latitude:longitude, such as
+// "Москва:55.7558:37.6173;Berlin:52.52:13.405".
+func courierZones() []courier.Zone {
+	value := os.Getenv("COURIER_ZONES")
+	if value == "" {
+		return courier.DefaultZones
+	}
+
+	var zones []courier.Zone
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || strings.TrimSpace(parts[0]) == "" {
+			log.Fatalf("Invalid COURIER_ZONES entry %q: must be name:latitude:longitude", entry)
+		}
+		latitude, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || latitude < -90 || latitude > 90 {
+			log.Fatalf("Invalid COURIER_ZONES entry %q: latitude must be between -90 and 90", entry)
+		}
+		longitude, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || longitude < -180 || longitude > 180 {
+			log.Fatalf("Invalid COURIER_ZONES entry %q: longitude must be between -180 and 180", entry)
+		}
+		zones = append(zones, courier.Zone{Name: strings.TrimSpace(parts[0]), Latitude: latitude, Longitude: longitude})
+	}
+	return zones
+}
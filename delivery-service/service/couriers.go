@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/delivery"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/courier"
+)
+
+// RegisterCourier adds a courier working in one of the configured zones. New
+// couriers are off shift until SetCourierShift starts their shift.
+func (s *Server) RegisterCourier(ctx context.Context, req *delivery.RegisterCourierRequest) (*delivery.Courier, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "name is required")
+	}
+	if req.Capacity <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "capacity must be positive")
+	}
+	zone, ok := s.couriers.Zone(req.Zone)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown zone %q", req.Zone)
+	}
+
+	courierId := uuid.New().String()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO couriers (id, name, phone, zone, capacity, on_shift, created_at, updated_at)
+		 VALUES ($1, $2, NULLIF($3, ''), $4, $5, FALSE, $6, $6)`,
+		courierId, name, req.Phone, zone.Name, req.Capacity, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register courier: %w", err)
+	}
+
+	log.Printf("Courier %s registered in %s with capacity %d", courierId, zone.Name, req.Capacity)
+	return &delivery.Courier{
+		CourierId: courierId,
+		Name:      name,
+		Phone:     req.Phone,
+		Zone:      zone.Name,
+		Capacity:  req.Capacity,
+	}, nil
+}
+
+// SetCourierShift starts or ends a courier's shift. A courier going off shift
+// hands their deliveries over to other couriers; a courier coming on shift
+// lets deliveries waiting for a courier be assigned. Setting the shift a
+// courier is already in changes nothing.
+func (s *Server) SetCourierShift(ctx context.Context, req *delivery.SetCourierShiftRequest) (*delivery.SetCourierShiftResponse, error) {
+	if _, err := uuid.Parse(req.CourierId); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid courier id %q", req.CourierId)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := courier.Lock(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	var onShift bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT on_shift FROM couriers WHERE id = $1 FOR UPDATE`,
+		req.CourierId,
+	).Scan(&onShift)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "courier %s not found", req.CourierId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get courier: %w", err)
+	}
+
+	response := &delivery.SetCourierShiftResponse{}
+	if onShift != req.OnShift {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE couriers SET on_shift = $1, updated_at = $2 WHERE id = $3`,
+			req.OnShift, time.Now(), req.CourierId,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update courier: %w", err)
+		}
+
+		var assigned, waiting int
+		if req.OnShift {
+			assigned, waiting, err = s.couriers.AssignWaiting(ctx, tx)
+		} else {
+			assigned, waiting, err = s.couriers.Reassign(ctx, tx, req.CourierId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		response.Assigned = int32(assigned)
+		response.Waiting = int32(waiting)
+	}
+
+	response.Courier, err = getCourier(ctx, tx, req.CourierId)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Courier %s on shift: %t, %d deliveries assigned, %d waiting",
+		req.CourierId, req.OnShift, response.Assigned, response.Waiting)
+	return response, nil
+}
+
+func getCourier(ctx context.Context, tx *sql.Tx, courierId string) (*delivery.Courier, error) {
+	var c delivery.Courier
+	err := tx.QueryRowContext(ctx,
+		`SELECT c.id, c.name, COALESCE(c.phone, ''), c.zone, c.capacity, c.on_shift, COUNT(d.id)
+		 FROM couriers c
+		 LEFT JOIN courier_assignments s ON s.courier_id = c.id AND s.released_at IS NULL
+		 LEFT JOIN deliveries d ON d.id = s.delivery_id AND d.status IN ('PENDING', 'IN_TRANSIT', 'OUT_FOR_DELIVERY')
+		 WHERE c.id = $1
+		 GROUP BY c.id`,
+		courierId,
+	).Scan(&c.CourierId, &c.Name, &c.Phone, &c.Zone, &c.Capacity, &c.OnShift, &c.ActiveDeliveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get courier: %w", err)
+	}
+	return &c, nil
+}
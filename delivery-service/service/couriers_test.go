@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/5rfy/micro-delivery/proto/generated/delivery"
+	"github.com/google/uuid"
+	"main.go/courier"
+	"main.go/database"
+)
+
+func TestFinishedDeliveryFreesItsCourierForAWaitingOne(t *testing.T) {
+	tests := map[string]func(t *testing.T, s *Server, w *ProgressWorker, first *delivery.CreateDeliveryResponse, orderId string) error{
+		"delivered": func(t *testing.T, s *Server, w *ProgressWorker, first *delivery.CreateDeliveryResponse, orderId string) error {
+			return inTx(t, s.db, func(tx *sql.Tx) error {
+				return w.applyTransition(context.Background(), tx, transition{
+					deliveryId: first.DeliveryId, orderId: orderId, status: "DELIVERED", location: "Delivered to address",
+					trackingNumber: first.TrackingNumber, estimatedDelivery: first.EstimatedDelivery,
+				})
+			})
+		},
+		"cancelled": func(t *testing.T, s *Server, _ *ProgressWorker, _ *delivery.CreateDeliveryResponse, orderId string) error {
+			return inTx(t, s.db, func(tx *sql.Tx) error {
+				return s.CancelDeliveryTx(context.Background(), tx, orderId, "order cancelled")
+			})
+		},
+	}
+	for name, finish := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := database.InitDb(testdb.New(t))
+			t.Cleanup(func() { db.Close() })
+			couriers := courier.NewAssigner(courier.DefaultZones)
+			s := NewServer(db, couriers)
+			w := NewProgressWorker(db, couriers)
+			ctx := context.Background()
+
+			c, err := s.RegisterCourier(ctx, &delivery.RegisterCourierRequest{Name: "Petr", Zone: "Москва", Capacity: 1})
+			if err != nil {
+				t.Fatalf("RegisterCourier: %v", err)
+			}
+			if _, err := s.SetCourierShift(ctx, &delivery.SetCourierShiftRequest{CourierId: c.CourierId, OnShift: true}); err != nil {
+				t.Fatalf("SetCourierShift: %v", err)
+			}
+
+			orders := []string{uuid.New().String(), uuid.New().String()}
+			var created []*delivery.CreateDeliveryResponse
+			for _, orderId := range orders {
+				resp, err := s.CreateDelivery(ctx, &delivery.CreateDeliveryRequest{
+					OrderId: orderId, UserId: "user-1", DeliveryAddress: "Москва, ул. Ленина 1",
+				})
+				if err != nil {
+					t.Fatalf("CreateDelivery: %v", err)
+				}
+				created = append(created, resp)
+			}
+			if got := courierOf(t, s, orders[1]); got != "" {
+				t.Fatalf("second delivery went to courier %s at full capacity", got)
+			}
+
+			if err := finish(t, s, w, created[0], orders[0]); err != nil {
+				t.Fatalf("failed to finish the first delivery: %v", err)
+			}
+			if got := courierOf(t, s, orders[1]); got != c.CourierId {
+				t.Fatalf("second delivery courier = %q, want %q", got, c.CourierId)
+			}
+		})
+	}
+}
+
+func courierOf(t *testing.T, s *Server, orderId string) string {
+	resp, err := s.GetDeliveryStatus(context.Background(), &delivery.GetDeliveryStatusRequest{OrderId: orderId})
+	if err != nil {
+		t.Fatalf("GetDeliveryStatus: %v", err)
+	}
+	return resp.CourierId
+}
+
+func inTx(t *testing.T, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
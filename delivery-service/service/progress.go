@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"main.go/courier"
+	"main.go/structs"
+)
+
+const (
+	progressPollInterval = time.Second
+	progressBatchSize    = 50
+)
+
+// progressStep is one simulated move of a parcel, made delay after the
+// previous one.
+type progressStep struct {
+	status   string
+	location string
+	delay    time.Duration
+}
+
+var progressSteps = []progressStep{
+	{"IN_TRANSIT", "Warehouse A - Processed", 5 * time.Second},
+	{"IN_TRANSIT", "Sorting Center", 10 * time.Second},
+	{"OUT_FOR_DELIVERY", "Local Courier Hub", 15 * time.Second},
+	{"DELIVERED", "Delivered to address", 20 * time.Second},
+}
+
+// progressRank orders the statuses a delivery moves through.
+var progressRank = map[string]int{
+	"PENDING":          0,
+	"IN_TRANSIT":       1,
+	"OUT_FOR_DELIVERY": 2,
+	"DELIVERED":        3,
+}
+
+// scheduleProgress stores the transitions still ahead of a delivery in the
+// given status inside tx, due from now on. The ProgressWorker applies them.
+func scheduleProgress(ctx context.Context, tx *sql.Tx, deliveryId string, orderId string, status string) error {
+	due := time.Now()
+	for _, step := range progressSteps {
+		if progressRank[step.status] <= progressRank[status] {
+			continue
+		}
+		due = due.Add(step.delay)
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO delivery_transitions (delivery_id, order_id, status, location, due_at)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			deliveryId, orderId, step.status, step.location, due,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to schedule delivery transition: %w", err)
+		}
+	}
+	return nil
+}
+
+// ResumeInFlightDeliveries schedules the remaining transitions of deliveries
+// that are on their way but have none pending, such as those created before
+// transitions were stored. Replicas starting together take turns, so a
+// delivery is not scheduled twice.
+func ResumeInFlightDeliveries(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('delivery_transitions'))`); err != nil {
+		return fmt.Errorf("failed to lock delivery transitions: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, order_id, status FROM deliveries d
+		 WHERE status IN ('PENDING', 'IN_TRANSIT', 'OUT_FOR_DELIVERY')
+		   AND NOT EXISTS (SELECT 1 FROM delivery_transitions t WHERE t.delivery_id = d.id AND t.applied_at IS NULL)`)
+	if err != nil {
+		return fmt.Errorf("failed to select in-flight deliveries: %w", err)
+	}
+
+	type inFlight struct{ id, orderId, status string }
+	var deliveries []inFlight
+	for rows.Next() {
+		var d inFlight
+		if err := rows.Scan(&d.id, &d.orderId, &d.status); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to select in-flight deliveries: %w", err)
+	}
+
+	for _, d := range deliveries {
+		if err := scheduleProgress(ctx, tx, d.id, d.orderId, d.status); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if len(deliveries) > 0 {
+		log.Printf("Resumed progress of %d in-flight deliveries", len(deliveries))
+	}
+	return nil
+}
+
+// ProgressWorker applies due delivery transitions. Transitions are claimed
+// with FOR UPDATE SKIP LOCKED, and only the earliest pending transition of a
+// delivery is eligible, so the worker can run on every replica without
+// applying a delivery's transitions out of order. A transition is applied,
+// its event enqueued and the transition marked applied in one transaction,
+// so a crash in between makes the worker retry it rather than lose it. Each
+// transition of a batch runs under a savepoint of its own, so one that fails
+// is logged and left for the next tick without holding back the others. A
+// delivered parcel frees room with its courier for a waiting one.
+type ProgressWorker struct {
+	db       *sql.DB
+	couriers *courier.Assigner
+}
+
+func NewProgressWorker(db *sql.DB, couriers *courier.Assigner) *ProgressWorker {
+	return &ProgressWorker{db: db, couriers: couriers}
+}
+
+func (w *ProgressWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(progressPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.applyDue(ctx); err != nil {
+				log.Printf("Delivery progress error: %v", err)
+			}
+		}
+	}
+}
+
+type transition struct {
+	id                int64
+	deliveryId        string
+	orderId           string
+	status            string
+	location          string
+	trackingNumber    string
+	estimatedDelivery string
+}
+
+func (w *ProgressWorker) applyDue(ctx context.Context) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT t.id, t.delivery_id, t.order_id, t.status, t.location,
+		        COALESCE(d.tracking_number, ''), COALESCE(d.estimated_delivery, '')
+		 FROM delivery_transitions t JOIN deliveries d ON d.id = t.delivery_id
+		 WHERE t.applied_at IS NULL AND t.due_at <= $1
+		   AND NOT EXISTS (SELECT 1 FROM delivery_transitions e
+		                   WHERE e.delivery_id = t.delivery_id AND e.applied_at IS NULL AND e.id < t.id)
+		 ORDER BY t.due_at
+		 LIMIT $2
+		 FOR UPDATE OF t SKIP LOCKED`,
+		time.Now(), progressBatchSize,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to select due transitions: %w", err)
+	}
+
+	var due []transition
+	for rows.Next() {
+		var t transition
+		if err := rows.Scan(&t.id, &t.deliveryId, &t.orderId, &t.status, &t.location,
+			&t.trackingNumber, &t.estimatedDelivery); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan transition: %w", err)
+		}
+		due = append(due, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to select due transitions: %w", err)
+	}
+
+	for _, t := range due {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT transition`); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		if err := w.applyTransition(ctx, tx, t); err != nil {
+			log.Printf("Failed to advance delivery %s to %s, retrying later: %v", t.deliveryId, t.status, err)
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT transition`); err != nil {
+				return fmt.Errorf("failed to roll back to savepoint: %w", err)
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT transition`); err != nil {
+			return fmt.Errorf("failed to release savepoint: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// applyTransition moves the delivery to the transition's status and stores
+// the delivery.status.updated event in the outbox. A delivery cancelled in
+// the meantime does not move, and its pending transitions are dropped. Once
+// the delivery is delivered, its courier takes on a waiting delivery if any.
+func (w *ProgressWorker) applyTransition(ctx context.Context, tx *sql.Tx, t transition) error {
+	res, err := tx.ExecContext(ctx,
+		`UPDATE deliveries SET status = $1, current_location = $2, updated_at = $3
+		 WHERE id = $4 AND status <> 'CANCELLED'`,
+		t.status, t.location, time.Now(), t.deliveryId,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	if updated == 0 {
+		log.Printf("Delivery for order %s was cancelled, stopping progress", t.orderId)
+		return dropProgress(ctx, tx, t.deliveryId)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE delivery_transitions SET applied_at = $1 WHERE id = $2`,
+		time.Now(), t.id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark transition applied: %w", err)
+	}
+	if err := recordEvent(ctx, tx, t.deliveryId, t.status, t.location); err != nil {
+		return err
+	}
+
+	err = enqueueStatus(ctx, tx, structs.DeliveryStatusUpdatedEvent{
+		OrderId:        t.orderId,
+		DeliveryId:     t.deliveryId,
+		Status:         t.status,
+		TrackingNumber: t.trackingNumber,
+		EstimatedDate:  t.estimatedDelivery,
+	})
+	if err != nil {
+		return err
+	}
+	if t.status == "DELIVERED" {
+		if _, _, err := w.couriers.Freed(ctx, tx, t.deliveryId); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Delivery update for order %s: %s at %s", t.orderId, t.status, t.location)
+	return nil
+}
+
+// dropProgress deletes the transitions a delivery has not made yet.
+func dropProgress(ctx context.Context, tx *sql.Tx, deliveryId string) error {
+	_, err := tx.ExecContext(ctx,
+		`DELETE FROM delivery_transitions WHERE delivery_id = $1 AND applied_at IS NULL`,
+		deliveryId,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to drop delivery transitions: %w", err)
+	}
+	return nil
+}
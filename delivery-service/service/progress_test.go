@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/5rfy/micro-delivery/proto/generated/delivery"
+	"github.com/google/uuid"
+	"main.go/courier"
+	"main.go/database"
+)
+
+// startDeliveries creates n deliveries and makes all their transitions due.
+func startDeliveries(t *testing.T, n int) (*sql.DB, *Server, []string) {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+	couriers := courier.NewAssigner(nil)
+	s := NewServer(db, couriers)
+
+	var ids []string
+	for i := 0; i < n; i++ {
+		resp, err := s.CreateDelivery(context.Background(), &delivery.CreateDeliveryRequest{
+			OrderId: uuid.New().String(), UserId: "user-1", DeliveryAddress: "1 Main St",
+		})
+		if err != nil {
+			t.Fatalf("CreateDelivery: %v", err)
+		}
+		ids = append(ids, resp.DeliveryId)
+	}
+	if _, err := db.Exec(`UPDATE delivery_transitions SET due_at = NOW() - INTERVAL '1 minute'`); err != nil {
+		t.Fatalf("failed to make transitions due: %v", err)
+	}
+	return db, s, ids
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...any) int {
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	return n
+}
+
+func TestTwoWorkersApplyEveryTransitionOnceInOrder(t *testing.T) {
+	db, s, ids := startDeliveries(t, 20)
+	ctx := context.Background()
+
+	// A due transition is claimed by one worker or the other, so each round
+	// moves every delivery at least one step and the workers are done after
+	// as many rounds as there are steps.
+	for round := 0; round < len(progressSteps); round++ {
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- NewProgressWorker(db, s.couriers).applyDue(ctx)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("applyDue: %v", err)
+			}
+		}
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM delivery_transitions WHERE applied_at IS NULL`); n != 0 {
+		t.Fatalf("%d transitions left after %d rounds", n, len(progressSteps))
+	}
+
+	want := []string{"Processing"}
+	for _, step := range progressSteps {
+		want = append(want, step.location)
+	}
+	for _, id := range ids {
+		rows, err := db.Query(`SELECT location FROM delivery_events WHERE delivery_id = $1 ORDER BY id`, id)
+		if err != nil {
+			t.Fatalf("failed to get events: %v", err)
+		}
+		var got []string
+		for rows.Next() {
+			var location string
+			if err := rows.Scan(&location); err != nil {
+				t.Fatalf("failed to scan event: %v", err)
+			}
+			got = append(got, location)
+		}
+		rows.Close()
+		if !slices.Equal(got, want) {
+			t.Fatalf("delivery %s went through %v, want %v", id, got, want)
+		}
+	}
+	events := len(ids) * len(want)
+	if n := count(t, db, `SELECT COUNT(*) FROM outbox_events WHERE topic = $1`, TopicDeliveryUpdated); n != events {
+		t.Fatalf("status events = %d, want %d", n, events)
+	}
+}
+
+func TestFailingTransitionDoesNotHoldBackTheBatch(t *testing.T) {
+	db, s, ids := startDeliveries(t, 3)
+	poisoned := ids[0]
+	_, err := db.Exec(fmt.Sprintf(`CREATE FUNCTION fail_poisoned() RETURNS trigger AS $$
+		BEGIN
+			IF NEW.delivery_id = '%s' THEN RAISE EXCEPTION 'poisoned'; END IF;
+			RETURN NEW;
+		END $$ LANGUAGE plpgsql`, poisoned))
+	if err != nil {
+		t.Fatalf("failed to create trigger function: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TRIGGER fail_poisoned BEFORE INSERT ON delivery_events
+		FOR EACH ROW EXECUTE FUNCTION fail_poisoned()`); err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+
+	if err := NewProgressWorker(db, s.couriers).applyDue(context.Background()); err != nil {
+		t.Fatalf("applyDue: %v", err)
+	}
+
+	for _, id := range ids {
+		want := 1
+		if id == poisoned {
+			want = 0
+		}
+		if n := count(t, db, `SELECT COUNT(*) FROM delivery_transitions WHERE delivery_id = $1 AND applied_at IS NOT NULL`, id); n != want {
+			t.Fatalf("delivery %s has %d transitions applied, want %d", id, n, want)
+		}
+	}
+	if status := statusOf(t, db, poisoned); status != "PENDING" {
+		t.Fatalf("poisoned delivery status = %s, want PENDING", status)
+	}
+}
+
+func TestResumeAfterRestartSchedulesDeliveriesOnce(t *testing.T) {
+	db, s, ids := startDeliveries(t, 2)
+	ctx := context.Background()
+	if err := NewProgressWorker(db, s.couriers).applyDue(ctx); err != nil {
+		t.Fatalf("applyDue: %v", err)
+	}
+	// Deliveries from before transitions were stored have none pending.
+	if _, err := db.Exec(`DELETE FROM delivery_transitions WHERE applied_at IS NULL`); err != nil {
+		t.Fatalf("failed to drop transitions: %v", err)
+	}
+
+	// Two replicas start together.
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- ResumeInFlightDeliveries(ctx, db)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("ResumeInFlightDeliveries: %v", err)
+		}
+	}
+
+	for _, id := range ids {
+		if status := statusOf(t, db, id); status != "IN_TRANSIT" {
+			t.Fatalf("delivery %s status = %s, want IN_TRANSIT", id, status)
+		}
+		// IN_TRANSIT has OUT_FOR_DELIVERY and DELIVERED ahead of it.
+		if n := count(t, db, `SELECT COUNT(*) FROM delivery_transitions WHERE delivery_id = $1 AND applied_at IS NULL`, id); n != 2 {
+			t.Fatalf("delivery %s has %d transitions scheduled, want 2", id, n)
+		}
+	}
+}
+
+func statusOf(t *testing.T, db *sql.DB, deliveryId string) string {
+	var status string
+	if err := db.QueryRow(`SELECT status FROM deliveries WHERE id = $1`, deliveryId).Scan(&status); err != nil {
+		t.Fatalf("failed to get delivery: %v", err)
+	}
+	return status
+}
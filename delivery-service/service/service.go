@@ -7,13 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/outbox"
 	"github.com/5rfy/micro-delivery/proto/generated/delivery"
-	"github.com/IBM/sarama"
 	"github.com/google/uuid"
+	"main.go/courier"
 	"main.go/structs"
+	"main.go/tracking"
 )
 
 const TopicDeliveryUpdated = "delivery.status.updated"
@@ -21,32 +22,96 @@ const TopicDeliveryUpdated = "delivery.status.updated"
 type Server struct {
 	delivery.UnimplementedDeliveryServiceServer
 	db       *sql.DB
-	producer sarama.SyncProducer
+	couriers *courier.Assigner
 }
 
-func NewServer(db *sql.DB, producer sarama.SyncProducer) *Server {
+func NewServer(db *sql.DB, couriers *courier.Assigner) *Server {
 	return &Server{
 		db:       db,
-		producer: producer,
+		couriers: couriers,
 	}
 }
 
 func (s *Server) CreateDelivery(ctx context.Context, req *delivery.CreateDeliveryRequest) (*delivery.CreateDeliveryResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resp, err := s.CreateDeliveryTx(ctx, tx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return resp, nil
+}
+
+// CreateDeliveryTx stores a new delivery, its PENDING status event and the
+// transitions the ProgressWorker will move it through inside tx, and assigns
+// it a courier of its zone if one is free. The caller owns the commit.
+// Orders that were cancelled before the delivery was requested get a
+// CANCELLED response and no delivery.
+func (s *Server) CreateDeliveryTx(ctx context.Context, tx *sql.Tx, req *delivery.CreateDeliveryRequest) (*delivery.CreateDeliveryResponse, error) {
+	var cancelled bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM cancelled_orders WHERE order_id = $1)`,
+		req.OrderId,
+	).Scan(&cancelled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check cancellation: %w", err)
+	}
+	if cancelled {
+		return &delivery.CreateDeliveryResponse{Status: "CANCELLED"}, nil
+	}
+
 	deliveryId := uuid.New().String()
-	trackingNumber := generateTrackingNumber()
+	trackingNumber := tracking.Generate()
 	estimatedDelivery := time.Now().Add(3 * 24 * time.Hour).Format("2006-01-02")
-
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO deliveries (id, order_id, user_id, delivery_address, status, tracking_number, estimated_delivery, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		deliveryId, req.OrderId, req.UserId, req.DeliveryAddress,
-		"PENDING", trackingNumber, estimatedDelivery, time.Now(),
+	zone := s.couriers.ZoneOf(req.DeliveryAddress)
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO deliveries (id, order_id, user_id, delivery_address, recipient_name, recipient_phone,
+		                         status, tracking_number, estimated_delivery, zone, created_at)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), $11)`,
+		deliveryId, req.OrderId, req.UserId, req.DeliveryAddress, req.RecipientName, req.RecipientPhone,
+		"PENDING", trackingNumber, estimatedDelivery, zone, time.Now(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error inserting delivery %v", err)
 	}
+	if err := recordEvent(ctx, tx, deliveryId, "PENDING", "Processing"); err != nil {
+		return nil, err
+	}
+	if err := scheduleProgress(ctx, tx, deliveryId, req.OrderId, "PENDING"); err != nil {
+		return nil, err
+	}
+	if zone == "" {
+		log.Printf("Delivery %s is outside every courier zone", deliveryId)
+	} else {
+		courierId, err := s.couriers.Assign(ctx, tx, deliveryId, zone, courier.ReasonNewDelivery)
+		if errors.Is(err, courier.ErrNoCourier) {
+			log.Printf("Delivery %s in %s is waiting for a courier", deliveryId, zone)
+		} else if err != nil {
+			return nil, err
+		} else {
+			log.Printf("Delivery %s in %s assigned to courier %s", deliveryId, zone, courierId)
+		}
+	}
 
-	go s.simulateDeliveryProgress(req.OrderId, deliveryId, trackingNumber, estimatedDelivery)
+	err = enqueueStatus(ctx, tx, structs.DeliveryStatusUpdatedEvent{
+		OrderId:        req.OrderId,
+		DeliveryId:     deliveryId,
+		Status:         "PENDING",
+		TrackingNumber: trackingNumber,
+		EstimatedDate:  estimatedDelivery,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	log.Printf("Delivery %s created for order %s, tracking: %s", deliveryId, req.OrderId, trackingNumber)
 
@@ -62,11 +127,14 @@ func (s *Server) GetDeliveryStatus(ctx context.Context, req *delivery.GetDeliver
 	var resp delivery.GetDeliveryStatusResponse
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, order_id, status, tracking_number, estimated_delivery, current_location
-		 FROM deliveries WHERE order_id = $1`,
+		`SELECT d.id, d.order_id, d.status, d.tracking_number, d.estimated_delivery, d.current_location,
+		        COALESCE(d.delivery_address, ''), COALESCE(d.recipient_name, ''), COALESCE(s.courier_id::text, '')
+		 FROM deliveries d
+		 LEFT JOIN courier_assignments s ON s.delivery_id = d.id AND s.released_at IS NULL
+		 WHERE d.order_id = $1`,
 		req.OrderId,
 	).Scan(&resp.DeliveryId, &resp.OrderId, &resp.Status, &resp.TrackingNumber,
-		&resp.EstimatedDelivery, &resp.CurrentLocation)
+		&resp.EstimatedDelivery, &resp.CurrentLocation, &resp.DeliveryAddress, &resp.RecipientName, &resp.CourierId)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("delivery for order %s not found", req.OrderId)
@@ -78,57 +146,60 @@ func (s *Server) GetDeliveryStatus(ctx context.Context, req *delivery.GetDeliver
 	return &resp, nil
 }
 
-func (s *Server) simulateDeliveryProgress(orderId string, deliveryId string, trackingNumber string, estimatedDelivery string) {
-	statuses := []struct {
-		status   string
-		location string
-		delay    time.Duration
-	}{
-		{"IN_TRANSIT", "Warehouse A - Processed", 5 * time.Second},
-		{"IN_TRANSIT", "Sorting Center", 10 * time.Second},
-		{"OUT_FOR_DELIVERY", "Local Courier Hub", 15 * time.Second},
-		{"DELIVERED", "Delivered to address", 20 * time.Second},
-	}
-
-	for _, val := range statuses {
-		time.Sleep(val.delay)
-
-		s.db.Exec(
-			`UPDATE deliveries SET val = $1, current_location = $2, updated_at = $3 WHERE order_id = $4`,
-			val.status, val.location, time.Now(), orderId,
-		)
-
-		event := structs.DeliveryStatusUpdatedEvent{
-			OrderId:        orderId,
-			DeliveryId:     deliveryId,
-			Status:         val.status,
-			TrackingNumber: trackingNumber,
-			EstimatedDate:  estimatedDelivery,
-		}
-		payload, _ := json.Marshal(event)
-		s.publishEvent(TopicDeliveryUpdated, orderId, payload)
-
-		log.Printf("Delivery update for order %s: %s at %s", orderId, val.status, val.location)
+// CancelDeliveryTx compensates a cancelled order inside tx. A delivery that has
+// not reached OUT_FOR_DELIVERY is stopped, and the order is remembered so a
+// late create command does not create one. A status event is always sent as
+// the reply: CANCELLED if the delivery was stopped or never existed, otherwise
+// the status it could not be stopped in. The courier of a stopped delivery
+// takes on a waiting delivery if any.
+func (s *Server) CancelDeliveryTx(ctx context.Context, tx *sql.Tx, orderId string, reason string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO cancelled_orders (order_id, reason, cancelled_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (order_id) DO NOTHING`,
+		orderId, reason, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record cancellation: %w", err)
 	}
-}
 
-func (s *Server) publishEvent(topic, key string, payload []byte) {
-	msg := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(payload),
+	event := structs.DeliveryStatusUpdatedEvent{OrderId: orderId, Status: "CANCELLED"}
+	err = tx.QueryRowContext(ctx,
+		`UPDATE deliveries SET status = 'CANCELLED', current_location = 'Cancelled', updated_at = $1
+		 WHERE order_id = $2 AND status IN ('PENDING', 'IN_TRANSIT')
+		 RETURNING id, tracking_number, estimated_delivery`,
+		time.Now(), orderId,
+	).Scan(&event.DeliveryId, &event.TrackingNumber, &event.EstimatedDate)
+	if err == nil {
+		err = recordEvent(ctx, tx, event.DeliveryId, "CANCELLED", "Cancelled")
+		if err == nil {
+			err = dropProgress(ctx, tx, event.DeliveryId)
+		}
+		if err == nil {
+			_, _, err = s.couriers.Freed(ctx, tx, event.DeliveryId)
+		}
+	} else if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx,
+			`SELECT id, status, tracking_number, estimated_delivery FROM deliveries WHERE order_id = $1`,
+			orderId,
+		).Scan(&event.DeliveryId, &event.Status, &event.TrackingNumber, &event.EstimatedDate)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("No delivery to stop for order %s", orderId)
+			err = nil
+		} else if err == nil {
+			log.Printf("Delivery for order %s cannot be stopped in status %s", orderId, event.Status)
+		}
 	}
-	_, _, err := s.producer.SendMessage(msg)
 	if err != nil {
-		log.Printf("Failed to publish to %s: %v", topic, err)
+		return fmt.Errorf("failed to cancel delivery: %w", err)
 	}
+
+	return enqueueStatus(ctx, tx, event)
 }
 
-func generateTrackingNumber() string {
-	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	result := make([]byte, 12)
-	for i := range result {
-		result[i] = chars[rand.Intn(len(chars))]
+func enqueueStatus(ctx context.Context, tx *sql.Tx, event structs.DeliveryStatusUpdatedEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
 	}
-	return string(result)
+	return outbox.Enqueue(ctx, tx, TopicDeliveryUpdated, event.OrderId, payload)
 }
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/delivery"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/tracking"
+)
+
+// recordEvent appends a checkpoint to the tracking history of a delivery
+// inside tx. Every change of a delivery's status or location records one.
+func recordEvent(ctx context.Context, tx *sql.Tx, deliveryId string, status string, location string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO delivery_events (delivery_id, status, location, created_at) VALUES ($1, $2, $3, $4)`,
+		deliveryId, status, location, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record delivery event: %w", err)
+	}
+	return nil
+}
+
+// GetTrackingHistory returns the checkpoints the delivery of an order has
+// passed, oldest first.
+func (s *Server) GetTrackingHistory(ctx context.Context, req *delivery.GetTrackingHistoryRequest) (*delivery.GetTrackingHistoryResponse, error) {
+	if _, err := uuid.Parse(req.OrderId); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid order id %q", req.OrderId)
+	}
+
+	response := &delivery.GetTrackingHistoryResponse{OrderId: req.OrderId}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, tracking_number, status FROM deliveries WHERE order_id = $1`,
+		req.OrderId,
+	).Scan(&response.DeliveryId, &response.TrackingNumber, &response.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "delivery for order %s not found", req.OrderId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	response.Checkpoints, err = checkpoints(ctx, s.db, response.DeliveryId)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// TrackParcel returns the public view of the delivery with a tracking
+// number. Malformed numbers and numbers with a wrong check character are
+// rejected without a lookup. Numbers issued before check characters are only
+// found by exact match.
+func (s *Server) TrackParcel(ctx context.Context, req *delivery.TrackParcelRequest) (*delivery.TrackParcelResponse, error) {
+	number := tracking.Normalize(req.TrackingNumber)
+	if !tracking.Valid(number) && !tracking.Legacy(number) {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a valid tracking number", req.TrackingNumber)
+	}
+
+	var deliveryId string
+	response := &delivery.TrackParcelResponse{TrackingNumber: number}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, status, COALESCE(current_location, ''), COALESCE(estimated_delivery, '')
+		 FROM deliveries WHERE tracking_number = $1`,
+		number,
+	).Scan(&deliveryId, &response.Status, &response.CurrentLocation, &response.EstimatedDelivery)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "parcel %s not found", number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	response.Checkpoints, err = checkpoints(ctx, s.db, deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func checkpoints(ctx context.Context, db *sql.DB, deliveryId string) ([]*delivery.TrackingCheckpoint, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT status, location, created_at FROM delivery_events WHERE delivery_id = $1 ORDER BY id`,
+		deliveryId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracking history: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*delivery.TrackingCheckpoint
+	for rows.Next() {
+		var checkpoint delivery.TrackingCheckpoint
+		var createdAt time.Time
+		if err := rows.Scan(&checkpoint.Status, &checkpoint.Location, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tracking history: %w", err)
+		}
+		checkpoint.Timestamp = createdAt.Format(time.RFC3339)
+		result = append(result, &checkpoint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get tracking history: %w", err)
+	}
+	return result, nil
+}
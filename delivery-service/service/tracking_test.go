@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/5rfy/micro-delivery/proto/generated/delivery"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/courier"
+	"main.go/database"
+)
+
+func TestTrackParcelFindsLegacyNumbersOnlyByExactMatch(t *testing.T) {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+	s := NewServer(db, courier.NewAssigner(nil))
+	ctx := context.Background()
+
+	orderId := uuid.New().String()
+	if _, err := s.CreateDelivery(ctx, &delivery.CreateDeliveryRequest{
+		OrderId: orderId, UserId: "user-1", DeliveryAddress: "1 Main St",
+	}); err != nil {
+		t.Fatalf("CreateDelivery: %v", err)
+	}
+	// A number issued before check characters were added.
+	if _, err := db.Exec(`UPDATE deliveries SET tracking_number = 'K7Q2M9XW4T8B' WHERE order_id = $1`, orderId); err != nil {
+		t.Fatalf("failed to set legacy number: %v", err)
+	}
+
+	resp, err := s.TrackParcel(ctx, &delivery.TrackParcelRequest{TrackingNumber: " k7q2m9xw4t8b "})
+	if err != nil {
+		t.Fatalf("TrackParcel: %v", err)
+	}
+	if resp.Status != "PENDING" {
+		t.Fatalf("status = %s, want PENDING", resp.Status)
+	}
+
+	tests := map[string]codes.Code{
+		"K7Q2M9XW4T8C":  codes.NotFound,
+		"K7Q2M9XW4T8BT": codes.NotFound,
+		"K7Q2M9XW4T8CT": codes.InvalidArgument,
+		"K7Q2M9XW4T8":   codes.InvalidArgument,
+	}
+	for number, want := range tests {
+		_, err := s.TrackParcel(ctx, &delivery.TrackParcelRequest{TrackingNumber: number})
+		if got := status.Code(err); got != want {
+			t.Errorf("TrackParcel(%q) = %v, want %v", number, got, want)
+		}
+	}
+}
+
+func TestGetTrackingHistoryRejectsInvalidOrderIds(t *testing.T) {
+	// The order id is checked before the database is queried.
+	s := NewServer(nil, courier.NewAssigner(nil))
+	for _, orderId := range []string{"", "42", "not-a-uuid"} {
+		_, err := s.GetTrackingHistory(context.Background(), &delivery.GetTrackingHistoryRequest{OrderId: orderId})
+		if got := status.Code(err); got != codes.InvalidArgument {
+			t.Errorf("GetTrackingHistory(%q) = %v, want %v", orderId, got, codes.InvalidArgument)
+		}
+	}
+}
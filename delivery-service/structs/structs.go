@@ -1,12 +1,14 @@
 package structs
 
-import "github.com/shopspring/decimal"
-
-type PaymentCompletedEvent struct {
-	OrderId string          `json:"order_id"`
-	UserId  string          `json:"user_id"`
-	Amount  decimal.Decimal `json:"amount"`
-	Status  string          `json:"status"`
+type DeliveryCommand struct {
+	CommandId       string `json:"command_id"`
+	Type            string `json:"type"`
+	OrderId         string `json:"order_id"`
+	UserId          string `json:"user_id"`
+	DeliveryAddress string `json:"delivery_address"`
+	RecipientName   string `json:"recipient_name"`
+	RecipientPhone  string `json:"recipient_phone"`
+	Reason          string `json:"reason"`
 }
 
 type DeliveryStatusUpdatedEvent struct {
@@ -0,0 +1,86 @@
+// Package tracking generates and checks the tracking numbers printed on
+// parcels. A tracking number is twelve random characters followed by a check
+// character computed with the Luhn mod N algorithm over the same alphabet, so
+// a single mistyped character, and a swap of two neighbouring ones other
+// than 0 and Z, is caught before any lookup. The number is all it takes to
+// track a parcel, so it is drawn from crypto/rand.
+package tracking
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+const (
+	randomLength = 12
+	// Length is the length of a tracking number.
+	Length = randomLength + 1
+)
+
+// Generate returns a new tracking number.
+func Generate() string {
+	// Random bytes from the last, incomplete run of the alphabet are drawn
+	// again, or the first characters would come up more often than the rest.
+	limit := 256 / len(alphabet) * len(alphabet)
+	result := make([]byte, 0, Length)
+	var b [1]byte
+	for len(result) < randomLength {
+		rand.Read(b[:])
+		if int(b[0]) < limit {
+			result = append(result, alphabet[int(b[0])%len(alphabet)])
+		}
+	}
+	return string(append(result, checkCharacter(string(result))))
+}
+
+// Normalize returns number as it is stored: upper case, without surrounding
+// spaces.
+func Normalize(number string) string {
+	return strings.ToUpper(strings.TrimSpace(number))
+}
+
+// Valid reports whether a normalized number is a well-formed tracking
+// number with a matching check character. Numbers issued before check
+// characters were added are not valid: they cannot be told apart from a new
+// number with a character left out, see Legacy.
+func Valid(number string) bool {
+	return len(number) == Length && inAlphabet(number) &&
+		number[randomLength] == checkCharacter(number[:randomLength])
+}
+
+// Legacy reports whether a normalized number has the form of the numbers
+// issued before check characters were added: twelve characters of the
+// alphabet. Such a number carries no check, so it may only be looked up by
+// exact match, where a new number with a character left out finds nothing.
+func Legacy(number string) bool {
+	return len(number) == randomLength && inAlphabet(number)
+}
+
+func inAlphabet(number string) bool {
+	for i := 0; i < len(number); i++ {
+		if strings.IndexByte(alphabet, number[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCharacter computes the Luhn mod N check character of payload, which
+// must only use the alphabet.
+func checkCharacter(payload string) byte {
+	n := len(alphabet)
+	factor := 2
+	sum := 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, payload[i])
+		sum += addend/n + addend%n
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+	return alphabet[(n-sum%n)%n]
+}
@@ -0,0 +1,55 @@
+package tracking
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{"valid", "K7Q2M9XW4T8BT", true},
+		{"mistyped character", "K7Q2M9XW4T8CT", false},
+		{"mistyped check character", "K7Q2M9XW4T8BU", false},
+		{"neighbours swapped", "K7Q2M9WX4T8BT", false},
+		{"character left out", "K7Q2M9XW4T8T", false},
+		{"not normalized", "k7q2m9xw4t8bt", false},
+		{"outside the alphabet", "K7Q2M9XW4T-BT", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.number); got != tt.want {
+				t.Fatalf("Valid(%q) = %t, want %t", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	tests := map[string]bool{
+		"K7Q2M9XW4T8B":  true,
+		"K7Q2M9XW4T8BT": false,
+		"K7Q2M9XW4T8":   false,
+		"K7Q2M9XW4T-B":  false,
+	}
+	for number, want := range tests {
+		if got := Legacy(number); got != want {
+			t.Errorf("Legacy(%q) = %t, want %t", number, got, want)
+		}
+	}
+}
+
+func TestGenerateIssuesValidNumbers(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		number := Generate()
+		if !Valid(number) {
+			t.Fatalf("Generate() = %q, not valid", number)
+		}
+		if seen[number] {
+			t.Fatalf("Generate() repeated %q", number)
+		}
+		seen[number] = true
+	}
+}
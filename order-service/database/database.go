@@ -29,6 +29,23 @@ func InitDb(dsn string) *sql.DB {
 			delivery_address TEXT,
 			created_at TIMESTAMP DEFAULT NOW()
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_user_created ON orders (user_id, created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_user_status_created ON orders (user_id, status, created_at DESC, id DESC)`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_method VARCHAR(50) NOT NULL DEFAULT 'wallet'`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD'`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS recipient_name VARCHAR(255)`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS recipient_phone VARCHAR(50)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_pending_created ON orders (created_at) WHERE status = 'PENDING'`,
+		`CREATE TABLE IF NOT EXISTS order_items (
+			id BIGSERIAL PRIMARY KEY,
+			order_id UUID NOT NULL REFERENCES orders(id),
+			product_id VARCHAR(255) NOT NULL,
+			quantity INT NOT NULL,
+			price DECIMAL(10,2) NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_items_order ON order_items (order_id)`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD'`,
 		`CREATE TABLE IF NOT EXISTS delivery_statuses (
 			order_id UUID PRIMARY KEY REFERENCES orders(id),
 			status VARCHAR(50),
@@ -43,6 +60,59 @@ func InitDb(dsn string) *sql.DB {
 			sent BOOLEAN DEFAULT FALSE,
 			created_at TIMESTAMP DEFAULT NOW()
 		)`,
+		`ALTER TABLE outbox_events
+			ADD COLUMN IF NOT EXISTS seq BIGSERIAL,
+			ADD COLUMN IF NOT EXISTS event_key VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_error TEXT,
+			ADD COLUMN IF NOT EXISTS sent_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_events_unsent ON outbox_events (seq) WHERE sent = FALSE`,
+		`ALTER TABLE outbox_events
+			ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_events_sent ON outbox_events (sent_at) WHERE sent = TRUE`,
+		`CREATE TABLE IF NOT EXISTS processed_events (
+			event_id VARCHAR(255) PRIMARY KEY,
+			topic VARCHAR(255) NOT NULL,
+			processed_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS order_status_history (
+			id BIGSERIAL PRIMARY KEY,
+			order_id UUID NOT NULL REFERENCES orders(id),
+			from_status VARCHAR(50),
+			to_status VARCHAR(50) NOT NULL,
+			cause VARCHAR(255) NOT NULL,
+			event_id VARCHAR(255),
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history (order_id, id)`,
+		`CREATE TABLE IF NOT EXISTS sagas (
+			order_id UUID PRIMARY KEY REFERENCES orders(id),
+			user_id VARCHAR(255) NOT NULL,
+			amount DECIMAL(10,2) NOT NULL,
+			delivery_address TEXT,
+			step VARCHAR(50) NOT NULL,
+			status VARCHAR(50) NOT NULL,
+			attempts INT NOT NULL DEFAULT 1,
+			deadline_at TIMESTAMP,
+			last_error TEXT,
+			created_at TIMESTAMP DEFAULT NOW(),
+			updated_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`ALTER TABLE sagas ADD COLUMN IF NOT EXISTS payment_method VARCHAR(50)`,
+		`ALTER TABLE sagas ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD'`,
+		`ALTER TABLE sagas ADD COLUMN IF NOT EXISTS recipient_name VARCHAR(255)`,
+		`ALTER TABLE sagas ADD COLUMN IF NOT EXISTS recipient_phone VARCHAR(50)`,
+		`CREATE INDEX IF NOT EXISTS idx_sagas_deadline ON sagas (deadline_at) WHERE status IN ('RUNNING', 'COMPENSATING')`,
+		`CREATE TABLE IF NOT EXISTS saga_log (
+			id BIGSERIAL PRIMARY KEY,
+			order_id UUID NOT NULL REFERENCES sagas(order_id),
+			step VARCHAR(50) NOT NULL,
+			status VARCHAR(50) NOT NULL,
+			message TEXT,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_saga_log_order ON saga_log (order_id, id)`,
 	}
 
 	for _, migration := range migrations {
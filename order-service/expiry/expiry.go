@@ -0,0 +1,122 @@
+package expiry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/outbox"
+	"main.go/kafka"
+	"main.go/saga"
+	"main.go/statemachine"
+	"main.go/structs"
+)
+
+const (
+	DefaultTTL   = 15 * time.Minute
+	pollInterval = 30 * time.Second
+	batchSize    = 50
+)
+
+// Worker expires orders that stayed PENDING for longer than the TTL, which
+// happens when no payment outcome ever arrives. The saga re-sends the payment
+// command a bounded number of times before that; an expired order's saga is
+// aborted so a late payment is refunded. Orders are claimed with
+// FOR UPDATE SKIP LOCKED, so the worker can run on every replica.
+type Worker struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+func NewWorker(db *sql.DB, ttl time.Duration) *Worker {
+	return &Worker{db: db, ttl: ttl}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				expired, err := w.expireBatch(ctx)
+				if err != nil {
+					log.Printf("Order expiry error: %v", err)
+					break
+				}
+				if expired < batchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (w *Worker) expireBatch(ctx context.Context) (int, error) {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, user_id FROM orders
+		 WHERE status = $1 AND created_at <= $2
+		 ORDER BY created_at
+		 LIMIT $3
+		 FOR UPDATE SKIP LOCKED`,
+		statemachine.Pending, time.Now().Add(-w.ttl), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select pending orders: %w", err)
+	}
+
+	var events []structs.OrderExpiredEvent
+	for rows.Next() {
+		var event structs.OrderExpiredEvent
+		if err := rows.Scan(&event.OrderId, &event.UserId); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan order: %w", err)
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read pending orders: %w", err)
+	}
+
+	for _, event := range events {
+		if err := w.expire(ctx, tx, event); err != nil {
+			return 0, fmt.Errorf("failed to expire order %s: %w", event.OrderId, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(events), nil
+}
+
+func (w *Worker) expire(ctx context.Context, tx *sql.Tx, event structs.OrderExpiredEvent) error {
+	if _, err := statemachine.Transition(ctx, tx, event.OrderId, statemachine.Expired, "expiry", ""); err != nil {
+		return err
+	}
+
+	event.Reason = fmt.Sprintf("no payment outcome within %s", w.ttl)
+	event.ExpiredAt = time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if err := outbox.Enqueue(ctx, tx, kafka.TopicOrderExpired, event.OrderId, payload); err != nil {
+		return err
+	}
+
+	log.Printf("Order %s expired: %s", event.OrderId, event.Reason)
+	return saga.Abort(ctx, tx, event.OrderId, "order expired: "+event.Reason)
+}
@@ -4,21 +4,38 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/inbox"
+	"github.com/5rfy/micro-delivery/pkg/retry"
 	"github.com/IBM/sarama"
+	"main.go/saga"
+	"main.go/statemachine"
 	"main.go/structs"
 )
 
+// order.created, order.cancelled and order.expired are integration events:
+// the saga drives payment and delivery through commands, and no service in
+// this repository consumes them. They are published for other consumers,
+// such as analytics or notifications.
 const (
 	TopicOrderCreated      = "order.created"
+	TopicOrderCancelled    = saga.TopicOrderCancelled
+	TopicOrderExpired      = "order.expired"
 	TopicPaymentCompleted  = "payment.completed"
+	TopicPaymentRefunded   = "payment.refunded"
+	TopicPaymentCaptured   = "payment.captured"
 	TopicDeliveryCompleted = "delivery.status.updated"
 )
 
+const consumerGroup = "order-service-group"
+
 type ConsumerGroupHandler struct {
-	db *sql.DB
+	db    *sql.DB
+	retry *retry.Retrier
 }
 
 func (h *ConsumerGroupHandler) Setup(_ sarama.ConsumerGroupSession) error {
@@ -30,75 +47,184 @@ func (h *ConsumerGroupHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
 }
 
 func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		log.Printf("Received event from topic %s: %s", msg.Topic, string(msg.Value))
-
-		switch msg.Topic {
-		case TopicPaymentCompleted:
-			h.handlePaymentCompleted(msg.Value)
-		case TopicDeliveryCompleted:
-			h.handleDeliveryUpdated(msg.Value)
-		}
+	return h.retry.Consume(session, claim, h.handle)
+}
 
-		session.MarkMessage(msg, "")
+func (h *ConsumerGroupHandler) handle(msg *sarama.ConsumerMessage) error {
+	log.Printf("Received event from topic %s: %s", msg.Topic, string(msg.Value))
+
+	switch retry.SourceTopic(msg) {
+	case TopicPaymentCompleted:
+		return h.handlePaymentCompleted(inbox.EventId(msg), msg.Value)
+	case TopicPaymentRefunded:
+		return h.handlePaymentRefunded(inbox.EventId(msg), msg.Value)
+	case TopicPaymentCaptured:
+		return h.handlePaymentCaptured(inbox.EventId(msg), msg.Value)
+	case TopicDeliveryCompleted:
+		return h.handleDeliveryUpdated(inbox.EventId(msg), msg.Value)
 	}
 	return nil
 }
 
-func (h *ConsumerGroupHandler) handlePaymentCompleted(data []byte) {
+func (h *ConsumerGroupHandler) handlePaymentCompleted(id string, data []byte) error {
 	var event structs.PaymentCompletedEvent
 	if err := json.Unmarshal(data, &event); err != nil {
-		log.Printf("Failed to unmarshal event: %v", err)
-		return
+		return retry.Permanent(fmt.Errorf("failed to unmarshal event: %w", err))
 	}
 
-	newStatus := "PAYMENT_FAILED"
-	if event.Status == "SUCCESS" {
-		newStatus = "PAID"
-	} else if event.Status == "INSUFFICIENT_FUNDS" {
-		newStatus = "INSUFFICIENT_FUNDS"
+	// An authorized payment already secures the money; it is captured later
+	// by the saga, so the order counts as PAID from here on. A payment held
+	// for review is followed by another event once it has been reviewed.
+	newStatus := statemachine.PaymentFailed
+	switch event.Status {
+	case "AUTHORIZED", "SUCCESS":
+		newStatus = statemachine.Paid
+	case "REVIEW":
+		newStatus = statemachine.PaymentReview
+	case "INSUFFICIENT_FUNDS":
+		newStatus = statemachine.InsufficientFunds
+	case "LIMIT_EXCEEDED":
+		newStatus = statemachine.LimitExceeded
 	}
 
-	_, err := h.db.Exec(
-		`UPDATE orders SET status = $1 WHERE id = $2`,
-		newStatus, event.OrderId)
+	processed, err := inbox.Handle(context.Background(), h.db, id, TopicPaymentCompleted, func(tx *sql.Tx) error {
+		if err := transition(tx, event.OrderId, newStatus, TopicPaymentCompleted, id); err != nil {
+			return err
+		}
+		return saga.OnPaymentCompleted(context.Background(), tx, event.OrderId, event.Status)
+	})
 	if err != nil {
-		log.Printf("Failed to update order: %v", err)
-		return
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+	if !processed {
+		log.Printf("Skipping duplicate payment event %s for order %s", id, event.OrderId)
+		return nil
 	}
 	log.Printf("Order %s completed successfully", event.OrderId)
+	return nil
 }
 
-func (h *ConsumerGroupHandler) handleDeliveryUpdated(data []byte) {
+func (h *ConsumerGroupHandler) handlePaymentRefunded(id string, data []byte) error {
+	var event structs.PaymentRefundedEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return retry.Permanent(fmt.Errorf("failed to unmarshal event: %w", err))
+	}
+
+	processed, err := inbox.Handle(context.Background(), h.db, id, TopicPaymentRefunded, func(tx *sql.Tx) error {
+		if event.RefundedTotal.IsPositive() {
+			_, err := tx.Exec(`UPDATE orders SET refunded_amount = GREATEST(refunded_amount, $1) WHERE id = $2`,
+				event.RefundedTotal, event.OrderId)
+			if err != nil {
+				return fmt.Errorf("failed to update refunded amount: %w", err)
+			}
+		}
+		// A fully refunded order is REFUNDED; cancelled and expired orders keep
+		// their status, the refund being their compensation.
+		if event.Status == string(statemachine.Refunded) {
+			if err := transition(tx, event.OrderId, statemachine.Refunded, TopicPaymentRefunded, id); err != nil {
+				return err
+			}
+		}
+		return saga.OnPaymentRefunded(context.Background(), tx, event.OrderId)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply refund: %w", err)
+	}
+	if !processed {
+		log.Printf("Skipping duplicate refund event %s for order %s", id, event.OrderId)
+		return nil
+	}
+	log.Printf("Payment for order %s refunded (%s)", event.OrderId, event.Status)
+	return nil
+}
+
+func (h *ConsumerGroupHandler) handlePaymentCaptured(id string, data []byte) error {
+	var event structs.PaymentCapturedEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return retry.Permanent(fmt.Errorf("failed to unmarshal event: %w", err))
+	}
+
+	processed, err := inbox.Handle(context.Background(), h.db, id, TopicPaymentCaptured, func(tx *sql.Tx) error {
+		return saga.OnPaymentCaptured(context.Background(), tx, event.OrderId, event.Status, event.Reason)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply capture: %w", err)
+	}
+	if !processed {
+		log.Printf("Skipping duplicate capture event %s for order %s", id, event.OrderId)
+		return nil
+	}
+	log.Printf("Payment capture for order %s: %s", event.OrderId, event.Status)
+	return nil
+}
+
+func (h *ConsumerGroupHandler) handleDeliveryUpdated(id string, data []byte) error {
 	var event structs.DeliveryStatusEvent
 	if err := json.Unmarshal(data, &event); err != nil {
-		log.Printf("Failed to unmarshal event: %v", err)
-		return
+		return retry.Permanent(fmt.Errorf("failed to unmarshal event: %w", err))
 	}
 
-	_, err := h.db.Exec(`INSERT INTO delivery_statuses (order_id, status, tracking_number, estimated_delivery)
-		 VALUES ($1, $2, $3, $4)
-		 ON CONFLICT (order_id) DO UPDATE SET status = $2, tracking_number = $3`,
-		event.OrderId, event.Status, event.TrackingNumber, event.EstimatedDate)
+	processed, err := inbox.Handle(context.Background(), h.db, id, TopicDeliveryCompleted, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO delivery_statuses (order_id, status, tracking_number, estimated_delivery)
+			 VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (order_id) DO UPDATE SET status = $2, tracking_number = $3`,
+			event.OrderId, event.Status, event.TrackingNumber, event.EstimatedDate)
+		if err != nil {
+			return err
+		}
+
+		switch newStatus := statemachine.Status(event.Status); newStatus {
+		case statemachine.InTransit, statemachine.OutForDelivery, statemachine.Delivered:
+			if err := transition(tx, event.OrderId, newStatus, TopicDeliveryCompleted, id); err != nil {
+				return err
+			}
+		}
+		return saga.OnDeliveryUpdated(context.Background(), tx, event.OrderId, event.Status)
+	})
 	if err != nil {
-		log.Printf("Failed to update delivery: %v", err)
-		return
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	if !processed {
+		log.Printf("Skipping duplicate delivery event %s for order %s", id, event.OrderId)
+		return nil
 	}
 	log.Printf("Delivery status for order %s: %s", event.OrderId, event.Status)
+	return nil
 }
 
-func StartConsumer(db *sql.DB, brokers []string) {
+// transition applies an event to the order state machine. Illegal transitions
+// come from stale or out-of-order events; they are logged and dropped rather
+// than retried.
+func transition(tx *sql.Tx, orderId string, to statemachine.Status, topic string, id string) error {
+	from, err := statemachine.Transition(context.Background(), tx, orderId, to, topic, id)
+	if errors.Is(err, statemachine.ErrIllegalTransition) {
+		log.Printf("Ignoring %s event %s for order %s: %v", topic, id, orderId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if from != to {
+		log.Printf("Order %s: %s -> %s", orderId, from, to)
+	}
+	return nil
+}
+
+func StartConsumer(db *sql.DB, producer sarama.SyncProducer, brokers []string) {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
 
-	group, err := sarama.NewConsumerGroup(brokers, "order-service-group", config)
+	group, err := sarama.NewConsumerGroup(brokers, consumerGroup, config)
 	if err != nil {
 		log.Fatalf("Failed to create consumer group: %v", err)
 	}
 	defer group.Close()
 
-	handler := &ConsumerGroupHandler{db: db}
-	topics := []string{TopicPaymentCompleted, TopicDeliveryCompleted}
+	handler := &ConsumerGroupHandler{
+		db:    db,
+		retry: retry.NewRetrier(producer, retry.DefaultPolicy),
+	}
+	topics := retry.DefaultPolicy.Topics(TopicPaymentCompleted, TopicPaymentRefunded, TopicPaymentCaptured, TopicDeliveryCompleted)
 
 	for {
 		if err := group.Consume(context.Background(), topics, handler); err != nil {
@@ -107,3 +233,8 @@ func StartConsumer(db *sql.DB, brokers []string) {
 		}
 	}
 }
+
+// RedriveDLQ publishes everything parked on <topic>.dlq back to <topic>.
+func RedriveDLQ(brokers []string, topic string) error {
+	return retry.RedriveDLQ(brokers, consumerGroup, topic)
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
+	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/outbox"
 	"github.com/5rfy/micro-delivery/proto/generated/order"
 	"github.com/IBM/sarama"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"main.go/database"
+	"main.go/expiry"
 	"main.go/kafka"
+	"main.go/saga"
 	"main.go/service"
 )
 
@@ -25,6 +30,14 @@ func main() {
 		kafkaBrokers = []string{"localhost:9092"}
 	}
 
+	// redrive <topic> re-publishes <topic>.dlq to <topic> and exits.
+	if len(os.Args) == 3 && os.Args[1] == "redrive" {
+		if err := kafka.RedriveDLQ(kafkaBrokers, os.Args[2]); err != nil {
+			log.Fatalf("Failed to re-drive DLQ: %v", err)
+		}
+		return
+	}
+
 	db := database.InitDb(dsn)
 
 	config := sarama.NewConfig()
@@ -37,7 +50,18 @@ func main() {
 	}
 	defer producer.Close()
 
-	go kafka.StartConsumer(db, kafkaBrokers)
+	go kafka.StartConsumer(db, producer, kafkaBrokers)
+	go outbox.NewRelay(db, producer).Run(context.Background())
+	go saga.NewTimeoutWorker(db).Run(context.Background())
+
+	pendingTTL := expiry.DefaultTTL
+	if value := os.Getenv("ORDER_PENDING_TTL"); value != "" {
+		pendingTTL, err = time.ParseDuration(value)
+		if err != nil || pendingTTL <= 0 {
+			log.Fatalf("Invalid ORDER_PENDING_TTL %q: must be a positive duration such as 15m", value)
+		}
+	}
+	go expiry.NewWorker(db, pendingTTL).Run(context.Background())
 
 	port := os.Getenv("GRPC_PORT")
 	if port == "" {
@@ -50,13 +74,9 @@ func main() {
 	}
 
 	grpcServer := grpc.NewServer()
-	order.RegisterOrderServiceServer(grpcServer, service.NewServer(db, producer))
+	order.RegisterOrderServiceServer(grpcServer, service.NewServer(db))
 	reflection.Register(grpcServer)
 
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
-	}
-
 	log.Printf("Order service grpc listening on port %s", port)
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
@@ -0,0 +1,523 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/outbox"
+	"github.com/shopspring/decimal"
+	"main.go/statemachine"
+	"main.go/structs"
+)
+
+const (
+	TopicPaymentCommands  = "payment.commands"
+	TopicDeliveryCommands = "delivery.commands"
+	TopicOrderCancelled   = "order.cancelled"
+)
+
+const (
+	CommandProcessPayment = "PROCESS_PAYMENT"
+	CommandRefundPayment  = "REFUND_PAYMENT"
+	CommandCreateDelivery = "CREATE_DELIVERY"
+	CommandCancelDelivery = "CANCEL_DELIVERY"
+	CommandCapturePayment = "CAPTURE_PAYMENT"
+)
+
+type Step string
+
+// Forward steps run in order; compensation steps undo them in reverse. The
+// payment step only authorizes the amount; it is captured once the parcel
+// has been picked up, which the saga waits for without a deadline. A payment
+// held for a manual review is likewise waited for without a deadline.
+const (
+	StepPayment        Step = "PAYMENT"
+	StepPaymentReview  Step = "PAYMENT_REVIEW"
+	StepDelivery       Step = "DELIVERY"
+	StepAwaitPickup    Step = "AWAIT_PICKUP"
+	StepCapture        Step = "CAPTURE"
+	StepDone           Step = "DONE"
+	StepCancelDelivery Step = "CANCEL_DELIVERY"
+	StepRefundPayment  Step = "REFUND_PAYMENT"
+)
+
+type Status string
+
+const (
+	Running      Status = "RUNNING"
+	Completed    Status = "COMPLETED"
+	Aborted      Status = "ABORTED"
+	Compensating Status = "COMPENSATING"
+	Compensated  Status = "COMPENSATED"
+	Failed       Status = "FAILED"
+)
+
+// stepTimeouts is how long the coordinator waits for the reply to a step's
+// command before sending it again. Steps without a timeout have no deadline.
+var stepTimeouts = map[Step]time.Duration{
+	StepPayment:        30 * time.Second,
+	StepDelivery:       30 * time.Second,
+	StepCapture:        30 * time.Second,
+	StepCancelDelivery: 30 * time.Second,
+	StepRefundPayment:  30 * time.Second,
+}
+
+// maxAttempts is how many times a step's command is sent before the saga
+// gives up on the step: a forward step is compensated, a compensation step
+// leaves the saga FAILED for an operator.
+const maxAttempts = 3
+
+var (
+	ErrSagaNotFound   = errors.New("saga not found")
+	ErrNotCancellable = errors.New("order can no longer be cancelled")
+)
+
+// msgPaymentCaptured is logged once the payment has been captured; resume
+// looks for it to avoid capturing twice.
+const msgPaymentCaptured = "payment captured"
+
+// Saga is one order's run through payment and delivery.
+type Saga struct {
+	OrderId         string
+	UserId          string
+	Amount          decimal.Decimal
+	Currency        string
+	DeliveryAddress string
+	RecipientName   string
+	RecipientPhone  string
+	PaymentMethod   string
+	Step            Step
+	Status          Status
+	Attempts        int
+	DeadlineAt      sql.NullTime
+	LastError       string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// Start creates the saga for a new order and sends the payment command. The
+// caller fills in the order's details; the saga starts at the payment step.
+func Start(ctx context.Context, tx *sql.Tx, s *Saga) error {
+	now := time.Now()
+	s.Step, s.Status, s.Attempts = StepPayment, Running, 1
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO sagas (order_id, user_id, amount, currency, delivery_address, recipient_name, recipient_phone, payment_method,
+		                    step, status, attempts, deadline_at, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $13)`,
+		s.OrderId, s.UserId, s.Amount, s.Currency, s.DeliveryAddress, s.RecipientName, s.RecipientPhone, s.PaymentMethod,
+		s.Step, s.Status, s.Attempts, now.Add(stepTimeouts[StepPayment]), now)
+	if err != nil {
+		return fmt.Errorf("failed to insert saga: %w", err)
+	}
+
+	if err := logStep(ctx, tx, s, "saga started"); err != nil {
+		return err
+	}
+	return sendCommand(ctx, tx, s, "")
+}
+
+// OnPaymentCompleted moves the saga past the payment step, or makes it wait
+// for the review of a payment held by the risk screening.
+func OnPaymentCompleted(ctx context.Context, tx *sql.Tx, orderId string, paymentStatus string) error {
+	s, err := lock(ctx, tx, orderId)
+	if err != nil || s == nil {
+		return err
+	}
+	if s.Status != Running || (s.Step != StepPayment && s.Step != StepPaymentReview) {
+		return nil
+	}
+
+	if paymentStatus == "REVIEW" {
+		if s.Step == StepPaymentReview {
+			return nil
+		}
+		return advance(ctx, tx, s, Running, StepPaymentReview, "payment held for review", "")
+	}
+
+	if paymentStatus != "AUTHORIZED" && paymentStatus != "SUCCESS" {
+		return finish(ctx, tx, s, Aborted, "payment "+paymentStatus)
+	}
+	return advance(ctx, tx, s, Running, StepDelivery, "payment authorized", "")
+}
+
+// OnDeliveryUpdated waits for the pickup once the delivery exists, captures
+// the payment once the parcel is on its way, or continues the compensation
+// once the delivery has been cancelled. A delivery that could not be stopped
+// ends the compensation before anything is refunded and resumes the saga.
+func OnDeliveryUpdated(ctx context.Context, tx *sql.Tx, orderId string, deliveryStatus string) error {
+	s, err := lock(ctx, tx, orderId)
+	if err != nil || s == nil {
+		return err
+	}
+
+	pickedUp := deliveryStatus == "IN_TRANSIT" || deliveryStatus == "OUT_FOR_DELIVERY" || deliveryStatus == "DELIVERED"
+	switch {
+	case s.Status == Running && (s.Step == StepDelivery || s.Step == StepAwaitPickup) && pickedUp:
+		return advance(ctx, tx, s, Running, StepCapture, "parcel "+deliveryStatus, "")
+	case s.Status == Running && s.Step == StepDelivery && deliveryStatus != "CANCELLED":
+		return advance(ctx, tx, s, Running, StepAwaitPickup, "delivery created", "")
+	case s.Status == Compensating && s.Step == StepCancelDelivery:
+		switch deliveryStatus {
+		case "CANCELLED":
+			return advance(ctx, tx, s, Compensating, StepRefundPayment, "delivery cancelled", s.LastError)
+		case "OUT_FOR_DELIVERY", "DELIVERED":
+			return resume(ctx, tx, s, deliveryStatus)
+		}
+	}
+	return nil
+}
+
+// OnPaymentCaptured completes the saga once the payment has been captured.
+// A capture that failed, because the hold expired, is left for an operator:
+// the parcel is already on its way.
+func OnPaymentCaptured(ctx context.Context, tx *sql.Tx, orderId string, captureStatus string, reason string) error {
+	s, err := lock(ctx, tx, orderId)
+	if err != nil || s == nil {
+		return err
+	}
+	if s.Status == Compensating && captureStatus == "CAPTURED" {
+		// The capture overtook a cancel; resume needs to know about it if
+		// the delivery refuses to stop.
+		return logStep(ctx, tx, s, msgPaymentCaptured)
+	}
+	if s.Status != Running || s.Step != StepCapture {
+		return nil
+	}
+
+	if captureStatus != "CAPTURED" {
+		return finish(ctx, tx, s, Failed, "payment capture failed: "+reason)
+	}
+	return finish(ctx, tx, s, Completed, msgPaymentCaptured)
+}
+
+// OnPaymentRefunded completes the compensation. A CANCELLING order is
+// CANCELLED from here on.
+func OnPaymentRefunded(ctx context.Context, tx *sql.Tx, orderId string) error {
+	s, err := lock(ctx, tx, orderId)
+	if err != nil || s == nil {
+		return err
+	}
+	if s.Status != Compensating || s.Step != StepRefundPayment {
+		return nil
+	}
+	if err := finish(ctx, tx, s, Compensated, "payment refunded"); err != nil {
+		return err
+	}
+	return markCancelled(ctx, tx, orderId, s.LastError, "saga.compensated")
+}
+
+// Cancel cancels the order on the customer's request and returns the status
+// it had. The order stays CANCELLING while the saga stops the delivery and
+// then refunds the payment. Orders without a saga are cancelled at once, and
+// a saga that has already ended or is compensating returns ErrNotCancellable.
+func Cancel(ctx context.Context, tx *sql.Tx, orderId string, reason string) (statemachine.Status, error) {
+	from, err := statemachine.Transition(ctx, tx, orderId, statemachine.Cancelling, "CancelOrder", "")
+	if err != nil {
+		return from, err
+	}
+	if from == statemachine.Cancelling {
+		return from, ErrNotCancellable
+	}
+
+	s, err := lock(ctx, tx, orderId)
+	if err != nil {
+		return from, err
+	}
+	if s == nil {
+		return from, markCancelled(ctx, tx, orderId, reason, "CancelOrder")
+	}
+	if !compensable(s) {
+		return from, fmt.Errorf("%w: saga is %s", ErrNotCancellable, s.Status)
+	}
+	return from, compensate(ctx, tx, s, "order cancelled: "+reason)
+}
+
+// Abort starts compensating the saga, undoing whatever it has done so far.
+func Abort(ctx context.Context, tx *sql.Tx, orderId string, reason string) error {
+	s, err := lock(ctx, tx, orderId)
+	if err != nil || s == nil {
+		return err
+	}
+	return compensate(ctx, tx, s, reason)
+}
+
+// compensable reports whether compensate would undo anything.
+func compensable(s *Saga) bool {
+	return s.Status == Running || s.Status == Completed
+}
+
+func compensate(ctx context.Context, tx *sql.Tx, s *Saga, reason string) error {
+	switch {
+	case s.Status == Running && (s.Step == StepPayment || s.Step == StepPaymentReview):
+		return advance(ctx, tx, s, Compensating, StepRefundPayment, "compensating: "+reason, reason)
+	case s.Status == Running && (s.Step == StepDelivery || s.Step == StepAwaitPickup || s.Step == StepCapture),
+		s.Status == Completed:
+		return advance(ctx, tx, s, Compensating, StepCancelDelivery, "compensating: "+reason, reason)
+	}
+	return nil
+}
+
+// resume returns to the forward path once the delivery has refused to stop:
+// the parcel is with the courier, so the payment is captured, unless that
+// already happened, and the order ships.
+func resume(ctx context.Context, tx *sql.Tx, s *Saga, deliveryStatus string) error {
+	message := "cancel refused, parcel " + deliveryStatus
+
+	var captured bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM saga_log WHERE order_id = $1 AND message = $2)`,
+		s.OrderId, msgPaymentCaptured,
+	).Scan(&captured)
+	if err != nil {
+		return fmt.Errorf("failed to check capture: %w", err)
+	}
+
+	if captured {
+		return finish(ctx, tx, s, Completed, message)
+	}
+	return advance(ctx, tx, s, Running, StepCapture, message, "")
+}
+
+// advance moves the saga to the next step and sends that step's command.
+func advance(ctx context.Context, tx *sql.Tx, s *Saga, status Status, step Step, message string, reason string) error {
+	now := time.Now()
+	var deadline sql.NullTime
+	if timeout, ok := stepTimeouts[step]; ok {
+		deadline = sql.NullTime{Time: now.Add(timeout), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`UPDATE sagas SET step = $1, status = $2, attempts = 1, deadline_at = $3, last_error = NULLIF($4, ''), updated_at = $5
+		 WHERE order_id = $6`,
+		step, status, deadline, reason, now, s.OrderId)
+	if err != nil {
+		return fmt.Errorf("failed to update saga: %w", err)
+	}
+
+	s.Step, s.Status, s.Attempts, s.LastError = step, status, 1, reason
+	if err := logStep(ctx, tx, s, message); err != nil {
+		return err
+	}
+	return sendCommand(ctx, tx, s, reason)
+}
+
+// finish ends the saga without further commands.
+func finish(ctx context.Context, tx *sql.Tx, s *Saga, status Status, message string) error {
+	step := StepDone
+	lastError := ""
+	if status == Failed || status == Aborted {
+		step = s.Step
+		lastError = message
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`UPDATE sagas SET step = $1, status = $2, deadline_at = NULL, last_error = NULLIF($3, ''), updated_at = $4
+		 WHERE order_id = $5`,
+		step, status, lastError, time.Now(), s.OrderId)
+	if err != nil {
+		return fmt.Errorf("failed to update saga: %w", err)
+	}
+
+	s.Step, s.Status = step, status
+	log.Printf("Saga %s %s: %s", s.OrderId, status, message)
+	return logStep(ctx, tx, s, message)
+}
+
+// sendCommand enqueues the command of the saga's current step. The command id
+// only depends on the saga and the step, so a re-sent command is deduplicated
+// by the receiving service if the first copy was already processed.
+func sendCommand(ctx context.Context, tx *sql.Tx, s *Saga, reason string) error {
+	commandId := fmt.Sprintf("%s:%s", s.OrderId, s.Step)
+
+	var topic string
+	var command any
+	switch s.Step {
+	case StepPayment, StepCapture, StepRefundPayment:
+		commandType := CommandProcessPayment
+		switch s.Step {
+		case StepCapture:
+			commandType = CommandCapturePayment
+		case StepRefundPayment:
+			commandType = CommandRefundPayment
+		}
+		topic = TopicPaymentCommands
+		command = structs.PaymentCommand{
+			CommandId:       commandId,
+			Type:            commandType,
+			OrderId:         s.OrderId,
+			UserId:          s.UserId,
+			Amount:          s.Amount,
+			Currency:        s.Currency,
+			PaymentMethod:   s.PaymentMethod,
+			DeliveryAddress: s.DeliveryAddress,
+			Reason:          reason,
+		}
+	case StepDelivery, StepCancelDelivery:
+		commandType := CommandCreateDelivery
+		if s.Step == StepCancelDelivery {
+			commandType = CommandCancelDelivery
+		}
+		topic = TopicDeliveryCommands
+		command = structs.DeliveryCommand{
+			CommandId:       commandId,
+			Type:            commandType,
+			OrderId:         s.OrderId,
+			UserId:          s.UserId,
+			DeliveryAddress: s.DeliveryAddress,
+			RecipientName:   s.RecipientName,
+			RecipientPhone:  s.RecipientPhone,
+			Reason:          reason,
+		}
+	default:
+		return nil
+	}
+
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command: %w", err)
+	}
+	return outbox.Enqueue(ctx, tx, topic, s.OrderId, payload)
+}
+
+func logStep(ctx context.Context, tx *sql.Tx, s *Saga, message string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO saga_log (order_id, step, status, message, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		s.OrderId, s.Step, s.Status, message, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to write saga log: %w", err)
+	}
+	return nil
+}
+
+// lock loads the saga row for update. Orders created before the coordinator
+// existed have no saga, which is reported as nil without an error.
+func lock(ctx context.Context, tx *sql.Tx, orderId string) (*Saga, error) {
+	s, err := scan(tx.QueryRowContext(ctx, selectSaga+` WHERE order_id = $1 FOR UPDATE`, orderId))
+	if errors.Is(err, ErrSagaNotFound) {
+		return nil, nil
+	}
+	return s, err
+}
+
+// markCancelled moves a CANCELLING order to CANCELLED and publishes
+// order.cancelled. Orders in any other status, such as expired ones whose
+// late payment was refunded, keep it.
+func markCancelled(ctx context.Context, tx *sql.Tx, orderId string, reason string, cause string) error {
+	_, err := statemachine.Transition(ctx, tx, orderId, statemachine.Cancelled, cause, "")
+	if errors.Is(err, statemachine.ErrIllegalTransition) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	event := structs.OrderCancelledEvent{OrderId: orderId, Reason: reason, CancelledAt: time.Now()}
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM orders WHERE id = $1`, orderId).Scan(&event.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return outbox.Enqueue(ctx, tx, TopicOrderCancelled, orderId, payload)
+}
+
+// cancelOrder moves the order to CANCELLING when the saga gives up on it; it
+// is CANCELLED once the compensation has refunded the payment.
+func cancelOrder(ctx context.Context, tx *sql.Tx, orderId string, cause string) error {
+	_, err := statemachine.Transition(ctx, tx, orderId, statemachine.Cancelling, cause, "")
+	return err
+}
+
+const selectSaga = `SELECT order_id, user_id, amount, currency, COALESCE(delivery_address, ''),
+	COALESCE(recipient_name, ''), COALESCE(recipient_phone, ''), COALESCE(payment_method, ''), step, status, attempts,
+	deadline_at, COALESCE(last_error, ''), created_at, updated_at FROM sagas`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Saga, error) {
+	var s Saga
+	err := row.Scan(&s.OrderId, &s.UserId, &s.Amount, &s.Currency, &s.DeliveryAddress,
+		&s.RecipientName, &s.RecipientPhone, &s.PaymentMethod, &s.Step, &s.Status, &s.Attempts,
+		&s.DeadlineAt, &s.LastError, &s.CreatedAt, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSagaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saga: %w", err)
+	}
+	return &s, nil
+}
+
+// LogEntry is one recorded step change of a saga.
+type LogEntry struct {
+	Step      Step
+	Status    Status
+	Message   string
+	CreatedAt time.Time
+}
+
+// Get returns the saga of an order together with its step log.
+func Get(ctx context.Context, db *sql.DB, orderId string) (*Saga, []LogEntry, error) {
+	s, err := scan(db.QueryRowContext(ctx, selectSaga+` WHERE order_id = $1`, orderId))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT step, status, message, created_at FROM saga_log WHERE order_id = $1 ORDER BY id`,
+		orderId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get saga log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []LogEntry
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.Step, &e.Status, &e.Message, &e.CreatedAt); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan saga log: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to get saga log: %w", err)
+	}
+	return s, entries, nil
+}
+
+// ListStuck returns sagas that need attention: FAILED ones, and running or
+// compensating ones whose current step is overdue by at least overdue.
+func ListStuck(ctx context.Context, db *sql.DB, overdue time.Duration, limit int) ([]*Saga, error) {
+	rows, err := db.QueryContext(ctx,
+		selectSaga+` WHERE status = $1 OR (status IN ($2, $3) AND deadline_at <= $4)
+		 ORDER BY updated_at
+		 LIMIT $5`,
+		Failed, Running, Compensating, time.Now().Add(-overdue), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stuck sagas: %w", err)
+	}
+	defer rows.Close()
+
+	var sagas []*Saga
+	for rows.Next() {
+		s, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		sagas = append(sagas, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list stuck sagas: %w", err)
+	}
+	return sagas, nil
+}
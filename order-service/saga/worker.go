@@ -0,0 +1,143 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"main.go/statemachine"
+)
+
+const (
+	timeoutPollInterval = time.Second
+	timeoutBatchSize    = 50
+)
+
+// TimeoutWorker applies the per-step timeouts of running sagas. Overdue
+// sagas are claimed with FOR UPDATE SKIP LOCKED, so the worker can run on
+// every replica of the order service.
+type TimeoutWorker struct {
+	db *sql.DB
+}
+
+func NewTimeoutWorker(db *sql.DB) *TimeoutWorker {
+	return &TimeoutWorker{db: db}
+}
+
+func (w *TimeoutWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(timeoutPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.handleTimeouts(ctx); err != nil {
+				log.Printf("Saga timeout worker error: %v", err)
+			}
+		}
+	}
+}
+
+func (w *TimeoutWorker) handleTimeouts(ctx context.Context) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		selectSaga+` WHERE status IN ($1, $2) AND deadline_at <= $3
+		 ORDER BY deadline_at
+		 LIMIT $4
+		 FOR UPDATE SKIP LOCKED`,
+		Running, Compensating, time.Now(), timeoutBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to select overdue sagas: %w", err)
+	}
+
+	var overdue []*Saga
+	for rows.Next() {
+		s, err := scan(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		overdue = append(overdue, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read overdue sagas: %w", err)
+	}
+
+	for _, s := range overdue {
+		if err := timeout(ctx, tx, s); err != nil {
+			return fmt.Errorf("failed to time out saga %s: %w", s.OrderId, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// timeout re-sends the command of an overdue step. Once the attempts are used
+// up the payment step stops waiting and leaves the PENDING order to the expiry
+// job, other forward steps are compensated and the order cancelled, and a
+// stuck compensation leaves the saga FAILED for an operator to resolve.
+func timeout(ctx context.Context, tx *sql.Tx, s *Saga) error {
+	if s.Attempts < maxAttempts {
+		now := time.Now()
+		_, err := tx.ExecContext(ctx,
+			`UPDATE sagas SET attempts = attempts + 1, deadline_at = $1, updated_at = $2 WHERE order_id = $3`,
+			now.Add(stepTimeouts[s.Step]), now, s.OrderId)
+		if err != nil {
+			return fmt.Errorf("failed to update saga: %w", err)
+		}
+
+		s.Attempts++
+		log.Printf("Saga %s: step %s timed out, sending command again (attempt %d/%d)", s.OrderId, s.Step, s.Attempts, maxAttempts)
+		if err := logStep(ctx, tx, s, fmt.Sprintf("step timed out, retry %d/%d", s.Attempts, maxAttempts)); err != nil {
+			return err
+		}
+		return sendCommand(ctx, tx, s, s.LastError)
+	}
+
+	reason := fmt.Sprintf("step %s timed out after %d attempts", s.Step, maxAttempts)
+	if s.Status == Compensating {
+		return finish(ctx, tx, s, Failed, reason)
+	}
+	if s.Step == StepPayment {
+		return awaitExpiry(ctx, tx, s, reason)
+	}
+
+	err := cancelOrder(ctx, tx, s.OrderId, "saga.timeout")
+	if errors.Is(err, statemachine.ErrIllegalTransition) {
+		return finish(ctx, tx, s, Failed, reason+", order can no longer be cancelled")
+	}
+	if err != nil {
+		return err
+	}
+	return compensate(ctx, tx, s, reason)
+}
+
+// awaitExpiry clears the deadline of a saga whose payment step never got a
+// reply. The order stays PENDING until the expiry job expires it and aborts
+// the saga; a payment result arriving before that still moves the saga on.
+func awaitExpiry(ctx context.Context, tx *sql.Tx, s *Saga, reason string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE sagas SET deadline_at = NULL, last_error = $1, updated_at = $2 WHERE order_id = $3`,
+		reason, time.Now(), s.OrderId)
+	if err != nil {
+		return fmt.Errorf("failed to update saga: %w", err)
+	}
+
+	s.LastError = reason
+	log.Printf("Saga %s: %s, waiting for the order to expire", s.OrderId, reason)
+	return logStep(ctx, tx, s, reason+", waiting for order expiry")
+}
@@ -3,33 +3,69 @@ package service
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/outbox"
 	"github.com/5rfy/micro-delivery/proto/generated/order"
-	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"main.go/kafka"
+	"main.go/saga"
+	"main.go/statemachine"
 	"main.go/structs"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// defaultPaymentMethod pays from the user's wallet.
+const defaultPaymentMethod = "wallet"
+
+const defaultCurrency = "USD"
+
 type Server struct {
 	order.UnimplementedOrderServiceServer
-	db       *sql.DB
-	producer sarama.SyncProducer
+	db *sql.DB
 }
 
-func NewServer(db *sql.DB, producer sarama.SyncProducer) *Server {
-	return &Server{db: db, producer: producer}
+func NewServer(db *sql.DB) *Server {
+	return &Server{db: db}
 }
 
 func (s *Server) CreateOrder(ctx context.Context, req *order.CreateOrderRequest) (*order.CreateOrderResponse, error) {
 	orderId := uuid.New().String()
 	totalAmount := decimal.Zero
+	paymentMethod := req.PaymentMethod
+	if paymentMethod == "" {
+		paymentMethod = defaultPaymentMethod
+	}
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "currency must be a three-letter ISO 4217 code")
+	}
+	deliveryAddress := strings.TrimSpace(req.DeliveryAddress)
+	if deliveryAddress == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "delivery_address is required")
+	}
+	for _, item := range req.Items {
+		if item.Currency != "" && !strings.EqualFold(item.Currency, currency) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"item %s is priced in %s, but the order is in %s", item.ProductId, item.Currency, currency)
+		}
+	}
 
 	for _, item := range req.Items {
 		totalAmount = totalAmount.Add(decimal.NewFromFloat(item.Price).Mul(decimal.NewFromInt32(item.Quantity)))
@@ -39,28 +75,39 @@ func (s *Server) CreateOrder(ctx context.Context, req *order.CreateOrderRequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer func(tx *sql.Tx) {
-		err := tx.Rollback()
-		if err != nil {
-			log.Printf("failed to rollback transaction: %w", err)
-		}
-	}(tx)
+	defer tx.Rollback()
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO orders (id, user_id, status, total_amount, delivery_address, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)`,
-		orderId, req.UserId, "PENDING", totalAmount, req.DeliveryAddress, time.Now())
+		`INSERT INTO orders (id, user_id, status, total_amount, currency, delivery_address, recipient_name, recipient_phone,
+		                    payment_method, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10)`,
+		orderId, req.UserId, statemachine.Pending, totalAmount, currency, deliveryAddress, req.RecipientName, req.RecipientPhone,
+		paymentMethod, time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert order: %w", err)
 	}
 
-	items := make([]*structs.OrderItem, len(req.Items))
+	if err := statemachine.Record(ctx, tx, orderId, "", statemachine.Pending, "CreateOrder", ""); err != nil {
+		return nil, err
+	}
+
+	items := make([]*structs.OrderItem, 0, len(req.Items))
 
 	for _, item := range req.Items {
+		price := decimal.NewFromFloat(item.Price)
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO order_items (order_id, product_id, quantity, price, currency) VALUES ($1, $2, $3, $4, $5)`,
+			orderId, item.ProductId, item.Quantity, price, currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert order item: %w", err)
+		}
+
 		items = append(items, &structs.OrderItem{
 			ProductId: item.ProductId,
 			Quantity:  item.Quantity,
-			Price:     decimal.NewFromFloat(item.Price),
+			Price:     price,
+			Currency:  currency,
 		})
 	}
 
@@ -69,7 +116,10 @@ func (s *Server) CreateOrder(ctx context.Context, req *order.CreateOrderRequest)
 		UserId:          req.UserId,
 		Items:           items,
 		TotalAmount:     totalAmount,
-		DeliveryAddress: req.DeliveryAddress,
+		Currency:        currency,
+		DeliveryAddress: deliveryAddress,
+		RecipientName:   req.RecipientName,
+		RecipientPhone:  req.RecipientPhone,
 		CreatedAt:       time.Now(),
 	}
 
@@ -78,24 +128,33 @@ func (s *Server) CreateOrder(ctx context.Context, req *order.CreateOrderRequest)
 		return nil, fmt.Errorf("failed to marshal event: %w", err)
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO outbox_events (id, topic, payload, created_at) VALUES ($1, $2, $3, $4)`,
-		uuid.New().String(), kafka.TopicOrderCreated, string(eventJson), time.Now())
+	if err := outbox.Enqueue(ctx, tx, kafka.TopicOrderCreated, orderId, eventJson); err != nil {
+		return nil, err
+	}
+
+	err = saga.Start(ctx, tx, &saga.Saga{
+		OrderId:         orderId,
+		UserId:          req.UserId,
+		Amount:          totalAmount,
+		Currency:        currency,
+		DeliveryAddress: deliveryAddress,
+		RecipientName:   req.RecipientName,
+		RecipientPhone:  req.RecipientPhone,
+		PaymentMethod:   paymentMethod,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert outbox event: %w", err)
+		return nil, err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	go s.publishEvent(kafka.TopicOrderCreated, orderId, eventJson)
-
-	log.Printf("Order created: %s for user: %s, amount: %s", orderId, req.UserId, totalAmount)
+	log.Printf("Order created: %s for user: %s, amount: %s %s", orderId, req.UserId, totalAmount, currency)
 
 	return &order.CreateOrderResponse{
 		OrderId: orderId,
-		Status:  "PENDING",
+		Status:  string(statemachine.Pending),
 		Message: "Order created successfully, processing payment",
 	}, nil
 }
@@ -105,9 +164,13 @@ func (s *Server) GetOrder(ctx context.Context, req *order.GetOrderRequest) (*ord
 	var createdAt time.Time
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, user_id, status, total_amount, created_at FROM orders WHERE id = $1`,
+		`SELECT id, user_id, status, total_amount, currency, refunded_amount, payment_method,
+		        COALESCE(delivery_address, ''), COALESCE(recipient_name, ''), COALESCE(recipient_phone, ''), created_at
+		 FROM orders WHERE id = $1`,
 		req.OrderId,
-	).Scan(&response.OrderId, &response.UserId, &response.TotalAmount, &createdAt)
+	).Scan(&response.OrderId, &response.UserId, &response.Status, &response.TotalAmount, &response.Currency,
+		&response.RefundedAmount, &response.PaymentMethod,
+		&response.DeliveryAddress, &response.RecipientName, &response.RecipientPhone, &createdAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("response %s not found", req.OrderId)
 	}
@@ -115,6 +178,27 @@ func (s *Server) GetOrder(ctx context.Context, req *order.GetOrderRequest) (*ord
 		return nil, fmt.Errorf("failed to get response: %w", err)
 	}
 	response.CreatedAt = createdAt.Format(time.RFC3339)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT product_id, quantity, price, currency FROM order_items WHERE order_id = $1 ORDER BY id`,
+		req.OrderId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item order.OrderItem
+		if err := rows.Scan(&item.ProductId, &item.Quantity, &item.Price, &item.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		response.Items = append(response.Items, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+
 	return &response, nil
 }
 
@@ -138,16 +222,258 @@ func (s *Server) GetDeliveryStatus(ctx context.Context, req *order.GetDeliverySt
 	return &response, nil
 }
 
-func (s *Server) publishEvent(topic string, key string, payload []byte) {
-	msg := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(payload),
+// CancelOrder cancels an order while the state machine still allows it. The
+// order is CANCELLING until its saga has stopped the delivery and refunded the
+// debit; if the courier already has the parcel, the cancel is refused then and
+// the order ships.
+func (s *Server) CancelOrder(ctx context.Context, req *order.CancelOrderRequest) (*order.CancelOrderResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	from, err := saga.Cancel(ctx, tx, req.OrderId, req.Reason)
+	if errors.Is(err, statemachine.ErrOrderNotFound) {
+		return nil, status.Errorf(codes.NotFound, "order %s not found", req.OrderId)
+	}
+	if errors.Is(err, statemachine.ErrIllegalTransition) || errors.Is(err, saga.ErrNotCancellable) {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"order %s cannot be cancelled in status %s", req.OrderId, from)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel order: %w", err)
+	}
+
+	// Orders without a saga are already CANCELLED; the others are CANCELLING.
+	var to statemachine.Status
+	if err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, req.OrderId).Scan(&to); err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Order %s: %s, reason: %s", to, req.OrderId, req.Reason)
+
+	return &order.CancelOrderResponse{
+		OrderId: req.OrderId,
+		Status:  string(to),
+		Message: "Cancel accepted, the payment is refunded once the delivery is stopped",
+	}, nil
+}
+
+func (s *Server) GetOrderHistory(ctx context.Context, req *order.GetOrderHistoryRequest) (*order.GetOrderHistoryResponse, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`,
+		req.OrderId,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "order %s not found", req.OrderId)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT COALESCE(from_status, ''), to_status, cause, COALESCE(event_id, ''), created_at
+		 FROM order_status_history
+		 WHERE order_id = $1
+		 ORDER BY id`,
+		req.OrderId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order history: %w", err)
+	}
+	defer rows.Close()
+
+	response := &order.GetOrderHistoryResponse{OrderId: req.OrderId}
+	for rows.Next() {
+		var change order.OrderStatusChange
+		var changedAt time.Time
+		if err := rows.Scan(&change.FromStatus, &change.ToStatus, &change.Cause, &change.EventId, &changedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan order history: %w", err)
+		}
+		change.ChangedAt = changedAt.Format(time.RFC3339)
+		response.Changes = append(response.Changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order history: %w", err)
+	}
+
+	return response, nil
+}
+
+// ListOrders returns a user's orders, newest first, using keyset pagination on
+// (created_at, id). The cursor is opaque to clients and points past the last
+// order of the previous page.
+func (s *Server) ListOrders(ctx context.Context, req *order.ListOrdersRequest) (*order.ListOrdersResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	query := `SELECT id, user_id, status, total_amount, currency, created_at FROM orders WHERE user_id = $1`
+	args := []any{req.UserId}
+
+	if len(req.Statuses) > 0 {
+		placeholders := make([]string, len(req.Statuses))
+		for i, st := range req.Statuses {
+			args = append(args, st)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		query += fmt.Sprintf(" AND status IN (%s)", strings.Join(placeholders, ", "))
+	}
+
+	if req.CreatedFrom != "" {
+		from, err := time.Parse(time.RFC3339, req.CreatedFrom)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid created_from: %v", err)
+		}
+		args = append(args, from)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+
+	if req.CreatedTo != "" {
+		to, err := time.Parse(time.RFC3339, req.CreatedTo)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid created_to: %v", err)
+		}
+		args = append(args, to)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+
+	if req.Cursor != "" {
+		createdAt, id, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+		}
+		args = append(args, createdAt, id)
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	args = append(args, pageSize+1)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	response := &order.ListOrdersResponse{}
+	var lastCreatedAt time.Time
+	for rows.Next() {
+		var summary order.OrderSummary
+		var createdAt time.Time
+		if err := rows.Scan(&summary.OrderId, &summary.UserId, &summary.Status, &summary.TotalAmount, &summary.Currency, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+
+		if len(response.Orders) == pageSize {
+			response.NextCursor = encodeCursor(lastCreatedAt, response.Orders[pageSize-1].OrderId)
+			break
+		}
+
+		summary.CreatedAt = createdAt.Format(time.RFC3339)
+		response.Orders = append(response.Orders, &summary)
+		lastCreatedAt = createdAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+
+	return response, nil
+}
+
+func (s *Server) GetSaga(ctx context.Context, req *order.GetSagaRequest) (*order.GetSagaResponse, error) {
+	sg, entries, err := saga.Get(ctx, s.db, req.OrderId)
+	if errors.Is(err, saga.ErrSagaNotFound) {
+		return nil, status.Errorf(codes.NotFound, "saga for order %s not found", req.OrderId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := &order.GetSagaResponse{Saga: sagaToProto(sg)}
+	for _, e := range entries {
+		response.Log = append(response.Log, &order.SagaLogEntry{
+			Step:      string(e.Step),
+			Status:    string(e.Status),
+			Message:   e.Message,
+			CreatedAt: e.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return response, nil
+}
+
+func (s *Server) ListStuckSagas(ctx context.Context, req *order.ListStuckSagasRequest) (*order.ListStuckSagasResponse, error) {
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	sagas, err := saga.ListStuck(ctx, s.db, time.Duration(req.OverdueSeconds)*time.Second, limit)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, _, err := s.producer.SendMessage(msg); err != nil {
-		log.Printf("Failed to publish event to topic %s: %v", topic, err)
-	} else {
-		log.Printf("Event published to topic: %s", topic)
+	response := &order.ListStuckSagasResponse{}
+	for _, sg := range sagas {
+		response.Sagas = append(response.Sagas, sagaToProto(sg))
+	}
+	return response, nil
+}
+
+func sagaToProto(sg *saga.Saga) *order.Saga {
+	result := &order.Saga{
+		OrderId:   sg.OrderId,
+		Step:      string(sg.Step),
+		Status:    string(sg.Status),
+		Attempts:  int32(sg.Attempts),
+		LastError: sg.LastError,
+		CreatedAt: sg.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: sg.UpdatedAt.Format(time.RFC3339),
+	}
+	if sg.DeadlineAt.Valid {
+		result.DeadlineAt = sg.DeadlineAt.Time.Format(time.RFC3339)
+	}
+	return result
+}
+
+func encodeCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, "", errors.New("malformed cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return time.Time{}, "", err
 	}
+	return t, id, nil
 }
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/5rfy/micro-delivery/proto/generated/order"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/database"
+	"main.go/saga"
+	"main.go/structs"
+)
+
+// createDeliveryCommand is the CREATE_DELIVERY command the saga sends for an
+// order placed through POST /api/orders. The delivery-service tests feed the
+// same file to their consumer, so together the two prove that the address
+// of the order is the one stored for its delivery.
+const createDeliveryCommand = "../../testdata/create_delivery_command.json"
+
+func TestCreateOrderSendsTheDeliveryAddressToTheSaga(t *testing.T) {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+	ctx := context.Background()
+
+	resp, err := NewServer(db).CreateOrder(ctx, &order.CreateOrderRequest{
+		UserId:          "user-1",
+		Items:           []*order.OrderItem{{ProductId: "prod-1", Quantity: 1, Price: 10}},
+		DeliveryAddress: " ul. Lenina 1, Moscow ",
+		RecipientName:   "Ivan",
+		RecipientPhone:  "+7 900 000-00-00",
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	// The delivery is only requested once the payment is authorized.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := saga.OnPaymentCompleted(ctx, tx, resp.OrderId, "AUTHORIZED"); err != nil {
+		t.Fatalf("OnPaymentCompleted: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	var payload string
+	err = db.QueryRow(`SELECT payload FROM outbox_events WHERE topic = $1 AND event_key = $2`,
+		saga.TopicDeliveryCommands, resp.OrderId).Scan(&payload)
+	if err != nil {
+		t.Fatalf("failed to get delivery command: %v", err)
+	}
+	var command structs.DeliveryCommand
+	if err := json.Unmarshal([]byte(payload), &command); err != nil {
+		t.Fatalf("failed to unmarshal delivery command: %v", err)
+	}
+
+	fixture, err := os.ReadFile(createDeliveryCommand)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var want structs.DeliveryCommand
+	if err := json.Unmarshal([]byte(strings.ReplaceAll(string(fixture), "ORDER_ID", resp.OrderId)), &want); err != nil {
+		t.Fatalf("failed to unmarshal fixture: %v", err)
+	}
+	if command != want {
+		t.Fatalf("delivery command = %+v, want %+v", command, want)
+	}
+}
+
+func TestListOrdersRejectsTamperedCursors(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	tampered := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	cursors := map[string]string{
+		"not base64":    "%%%",
+		"no separator":  tampered(createdAt.Format(time.RFC3339Nano)),
+		"bad time":      tampered("yesterday|" + uuid.New().String()),
+		"id not a uuid": tampered(createdAt.Format(time.RFC3339Nano) + "|1' OR '1'='1"),
+		"empty id":      tampered(createdAt.Format(time.RFC3339Nano) + "|"),
+	}
+	for name, cursor := range cursors {
+		t.Run(name, func(t *testing.T) {
+			// The cursor is checked before the database is queried.
+			_, err := NewServer(nil).ListOrders(context.Background(), &order.ListOrdersRequest{UserId: "user-1", Cursor: cursor})
+			if got := status.Code(err); got != codes.InvalidArgument {
+				t.Fatalf("ListOrders with %s cursor = %v, want %v", name, got, codes.InvalidArgument)
+			}
+		})
+	}
+
+	id := uuid.New().String()
+	gotAt, gotId, err := decodeCursor(encodeCursor(createdAt, id))
+	if err != nil || !gotAt.Equal(createdAt) || gotId != id {
+		t.Fatalf("decodeCursor(encodeCursor()) = %v, %q, %v, want %v, %q", gotAt, gotId, err, createdAt, id)
+	}
+}
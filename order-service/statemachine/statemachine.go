@@ -0,0 +1,106 @@
+package statemachine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Status string
+
+const (
+	Pending           Status = "PENDING"
+	PaymentReview     Status = "PAYMENT_REVIEW"
+	Paid              Status = "PAID"
+	InsufficientFunds Status = "INSUFFICIENT_FUNDS"
+	LimitExceeded     Status = "LIMIT_EXCEEDED"
+	PaymentFailed     Status = "PAYMENT_FAILED"
+	InTransit         Status = "IN_TRANSIT"
+	OutForDelivery    Status = "OUT_FOR_DELIVERY"
+	Delivered         Status = "DELIVERED"
+	Cancelling        Status = "CANCELLING"
+	Cancelled         Status = "CANCELLED"
+	Expired           Status = "EXPIRED"
+	Refunded          Status = "REFUNDED"
+)
+
+// transitions lists the statuses an order may move to from each status.
+// Events arrive on different topics without a global order, so an order may
+// skip forward along the happy path (a delivery update can overtake the
+// payment result), but it never moves backwards. A cancelled order is
+// CANCELLING until the delivery has been stopped and the payment refunded; if
+// the courier already has the parcel, the cancel is refused and the order
+// moves on to OUT_FOR_DELIVERY or DELIVERED instead.
+var transitions = map[Status][]Status{
+	Pending:        {PaymentReview, Paid, InTransit, OutForDelivery, Delivered, InsufficientFunds, LimitExceeded, PaymentFailed, Cancelling, Cancelled, Expired},
+	PaymentReview:  {Paid, InTransit, OutForDelivery, Delivered, InsufficientFunds, PaymentFailed, Cancelling, Cancelled},
+	Paid:           {InTransit, OutForDelivery, Delivered, Cancelling, Cancelled, Refunded},
+	InTransit:      {OutForDelivery, Delivered, Cancelling, Cancelled, Refunded},
+	Cancelling:     {Cancelled, OutForDelivery, Delivered},
+	OutForDelivery: {Delivered, Refunded},
+	Delivered:      {Refunded},
+}
+
+var (
+	ErrIllegalTransition = errors.New("illegal order status transition")
+	ErrOrderNotFound     = errors.New("order not found")
+)
+
+// CanTransition reports whether an order in status from may move to status to.
+func CanTransition(from Status, to Status) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the order to status to inside tx and records the change
+// in order_status_history. The order row is locked, so concurrent events are
+// applied one after another. Moving to the current status is a no-op; any
+// other transition not allowed by the state machine returns ErrIllegalTransition.
+func Transition(ctx context.Context, tx *sql.Tx, orderId string, to Status, cause string, eventId string) (Status, error) {
+	var from Status
+	err := tx.QueryRowContext(ctx,
+		`SELECT status FROM orders WHERE id = $1 FOR UPDATE`,
+		orderId,
+	).Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrOrderNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get order status: %w", err)
+	}
+
+	if from == to {
+		return from, nil
+	}
+	if !CanTransition(from, to) {
+		return from, fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE orders SET status = $1 WHERE id = $2`,
+		to, orderId)
+	if err != nil {
+		return from, fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	return from, Record(ctx, tx, orderId, from, to, cause, eventId)
+}
+
+// Record appends a status change to order_status_history. An empty from marks
+// the initial status of a new order.
+func Record(ctx context.Context, tx *sql.Tx, orderId string, from Status, to Status, cause string, eventId string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO order_status_history (order_id, from_status, to_status, cause, event_id, created_at)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), $6)`,
+		orderId, from, to, cause, eventId, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
+	return nil
+}
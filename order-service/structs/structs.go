@@ -11,7 +11,10 @@ type OrderCreatedEvent struct {
 	UserId          string          `json:"user_id"`
 	Items           []*OrderItem    `json:"items"`
 	TotalAmount     decimal.Decimal `json:"total_amount"`
+	Currency        string          `json:"currency"`
 	DeliveryAddress string          `json:"delivery_address"`
+	RecipientName   string          `json:"recipient_name"`
+	RecipientPhone  string          `json:"recipient_phone"`
 	CreatedAt       time.Time       `json:"created_at"`
 }
 
@@ -19,6 +22,21 @@ type OrderItem struct {
 	ProductId string          `json:"product_id"`
 	Quantity  int32           `json:"quantity"`
 	Price     decimal.Decimal `json:"price"`
+	Currency  string          `json:"currency"`
+}
+
+type OrderCancelledEvent struct {
+	OrderId     string    `json:"order_id"`
+	UserId      string    `json:"user_id"`
+	Reason      string    `json:"reason"`
+	CancelledAt time.Time `json:"cancelled_at"`
+}
+
+type OrderExpiredEvent struct {
+	OrderId   string    `json:"order_id"`
+	UserId    string    `json:"user_id"`
+	Reason    string    `json:"reason"`
+	ExpiredAt time.Time `json:"expired_at"`
 }
 
 type PaymentCompletedEvent struct {
@@ -27,9 +45,49 @@ type PaymentCompletedEvent struct {
 	PaymentId string `json:"payment_id"`
 }
 
+type PaymentRefundedEvent struct {
+	OrderId       string          `json:"order_id"`
+	PaymentId     string          `json:"payment_id"`
+	RefundId      string          `json:"refund_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	RefundedTotal decimal.Decimal `json:"refunded_total"`
+	Status        string          `json:"status"`
+}
+
+type PaymentCapturedEvent struct {
+	OrderId   string          `json:"order_id"`
+	PaymentId string          `json:"payment_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason"`
+}
+
 type DeliveryStatusEvent struct {
 	OrderId        string `json:"order_id"`
 	Status         string `json:"status"`
 	TrackingNumber string `json:"tracking_number"`
 	EstimatedDate  string `json:"estimated_delivery"`
 }
+
+type PaymentCommand struct {
+	CommandId       string          `json:"command_id"`
+	Type            string          `json:"type"`
+	OrderId         string          `json:"order_id"`
+	UserId          string          `json:"user_id"`
+	Amount          decimal.Decimal `json:"amount"`
+	Currency        string          `json:"currency,omitempty"`
+	PaymentMethod   string          `json:"payment_method,omitempty"`
+	DeliveryAddress string          `json:"delivery_address,omitempty"`
+	Reason          string          `json:"reason,omitempty"`
+}
+
+type DeliveryCommand struct {
+	CommandId       string `json:"command_id"`
+	Type            string `json:"type"`
+	OrderId         string `json:"order_id"`
+	UserId          string `json:"user_id"`
+	DeliveryAddress string `json:"delivery_address"`
+	RecipientName   string `json:"recipient_name,omitempty"`
+	RecipientPhone  string `json:"recipient_phone,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
@@ -1,9 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
+
+	"github.com/shopspring/decimal"
+	"main.go/ledger"
 )
 
 func InitDb(url string) *sql.DB {
@@ -29,10 +34,188 @@ func InitDb(url string) *sql.DB {
 			status VARCHAR(50) NOT NULL,
 			created_at TIMESTAMP DEFAULT NOW()
 		)`,
-		`CREATE TABLE IF NOT EXISTS user_balances (
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payments_refund_within_amount') THEN
+				ALTER TABLE payments ADD CONSTRAINT payments_refund_within_amount
+					CHECK (refunded_amount >= 0 AND refunded_amount <= amount);
+			END IF;
+		END $$`,
+		`CREATE TABLE IF NOT EXISTS refunds (
+			id UUID PRIMARY KEY,
+			payment_id UUID NOT NULL REFERENCES payments(id),
+			order_id UUID NOT NULL,
+			amount DECIMAL(10,2) NOT NULL CHECK (amount > 0),
+			reason TEXT,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refunds_payment ON refunds (payment_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS accounts (
+			id VARCHAR(255) PRIMARY KEY,
+			kind VARCHAR(20) NOT NULL,
+			user_id VARCHAR(255),
+			balance DECIMAL(12,2) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT NOW(),
+			CONSTRAINT accounts_user_balance_non_negative CHECK (kind <> 'USER' OR balance >= 0)
+		)`,
+		`ALTER TABLE accounts ADD COLUMN IF NOT EXISTS held DECIMAL(12,2) NOT NULL DEFAULT 0`,
+		`ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD'`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'accounts_held_within_balance') THEN
+				ALTER TABLE accounts ADD CONSTRAINT accounts_held_within_balance CHECK (held >= 0 AND held <= balance);
+			END IF;
+		END $$`,
+		`INSERT INTO accounts (id, kind) VALUES ('system:opening', 'SYSTEM'), ('system:revenue', 'SYSTEM'), ('system:deposits', 'SYSTEM')
+		 ON CONFLICT (id) DO NOTHING`,
+		`CREATE TABLE IF NOT EXISTS journal_entries (
+			id UUID PRIMARY KEY,
+			type VARCHAR(50) NOT NULL,
+			reference VARCHAR(255),
+			description TEXT,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS postings (
+			id BIGSERIAL PRIMARY KEY,
+			entry_id UUID NOT NULL REFERENCES journal_entries(id),
+			account_id VARCHAR(255) NOT NULL REFERENCES accounts(id),
+			amount DECIMAL(12,2) NOT NULL CHECK (amount <> 0),
+			balance_after DECIMAL(12,2),
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS holds (
+			id UUID PRIMARY KEY,
+			account_id VARCHAR(255) NOT NULL REFERENCES accounts(id),
+			reference VARCHAR(255),
+			amount DECIMAL(12,2) NOT NULL CHECK (amount > 0),
+			status VARCHAR(20) NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT NOW(),
+			settled_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_holds_active_expires ON holds (expires_at) WHERE status = 'ACTIVE'`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS captured_at TIMESTAMP`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS provider VARCHAR(50) NOT NULL DEFAULT 'wallet'`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS provider_ref VARCHAR(255)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD'`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP`,
+		`DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'payments' AND column_name = 'hold_id') THEN
+				UPDATE payments p SET provider_ref = h.id::text, expires_at = h.expires_at
+					FROM holds h WHERE h.id = p.hold_id;
+				ALTER TABLE payments DROP COLUMN hold_id;
+			END IF;
+		END $$`,
+		`DO $$ BEGIN
+			IF EXISTS (
+				SELECT 1 FROM payments
+				WHERE status IN ('AUTHORIZED', 'SUCCESS', 'PARTIALLY_REFUNDED', 'REFUNDED')
+				GROUP BY order_id HAVING COUNT(*) > 1
+			) THEN
+				RAISE WARNING 'orders with more than one successful payment exist, refund the extra payments to enforce one payment per order';
+			ELSE
+				CREATE UNIQUE INDEX IF NOT EXISTS payments_one_successful_per_order ON payments (order_id)
+					WHERE status IN ('AUTHORIZED', 'SUCCESS', 'PARTIALLY_REFUNDED', 'REFUNDED');
+			END IF;
+		END $$`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS charged_amount DECIMAL(12,2)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS charged_currency VARCHAR(3)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS fx_rate DECIMAL(18,8)`,
+		`UPDATE payments SET charged_amount = amount, charged_currency = currency, fx_rate = 1
+		 WHERE charged_amount IS NULL AND provider_ref IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS fx_rates (
+			base VARCHAR(3) NOT NULL,
+			quote VARCHAR(3) NOT NULL,
+			rate DECIMAL(18,8) NOT NULL CHECK (rate > 0),
+			updated_at TIMESTAMP DEFAULT NOW(),
+			PRIMARY KEY (base, quote)
+		)`,
+		`INSERT INTO fx_rates (base, quote, rate) VALUES ('USD', 'EUR', 0.92), ('USD', 'GBP', 0.79), ('USD', 'RUB', 90.00)
+		 ON CONFLICT (base, quote) DO NOTHING`,
+		`CREATE TABLE IF NOT EXISTS payment_idempotency_keys (
+			key VARCHAR(255) PRIMARY KEY,
+			order_id UUID NOT NULL,
+			fingerprint VARCHAR(64) NOT NULL,
+			response TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_authorized_expires ON payments (expires_at) WHERE status = 'AUTHORIZED'`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk_score INT`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk_decision VARCHAR(20)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk_reasons TEXT`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS reviewed_by VARCHAR(255)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS review_note TEXT`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_review ON payments (created_at) WHERE status = 'REVIEW'`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_user_created ON payments (user_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS spending_limits (
 			user_id VARCHAR(255) PRIMARY KEY,
-			balance DECIMAL(10,2) NOT NULL DEFAULT 1000.00
+			per_order_limit DECIMAL(12,2) CHECK (per_order_limit > 0),
+			daily_limit DECIMAL(12,2) CHECK (daily_limit > 0),
+			updated_at TIMESTAMP DEFAULT NOW()
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_postings_account ON postings (account_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_postings_entry ON postings (entry_id)`,
+		`CREATE OR REPLACE FUNCTION ledger_check_entry_balanced() RETURNS trigger AS $$
+		BEGIN
+			IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+				RAISE EXCEPTION 'journal entry % does not balance', NEW.entry_id;
+			END IF;
+			IF (SELECT COUNT(DISTINCT a.currency) FROM postings p JOIN accounts a ON a.id = p.account_id
+				WHERE p.entry_id = NEW.entry_id) > 1 THEN
+				RAISE EXCEPTION 'journal entry % mixes currencies', NEW.entry_id;
+			END IF;
+			RETURN NULL;
+		END $$ LANGUAGE plpgsql`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'postings_balanced') THEN
+				CREATE CONSTRAINT TRIGGER postings_balanced AFTER INSERT ON postings
+					DEFERRABLE INITIALLY DEFERRED
+					FOR EACH ROW EXECUTE FUNCTION ledger_check_entry_balanced();
+			END IF;
+		END $$`,
+		`CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+		END $$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER journal_entries_append_only BEFORE UPDATE OR DELETE ON journal_entries
+			FOR EACH ROW EXECUTE FUNCTION ledger_append_only()`,
+		`CREATE OR REPLACE TRIGGER postings_append_only BEFORE UPDATE OR DELETE ON postings
+			FOR EACH ROW EXECUTE FUNCTION ledger_append_only()`,
+		`CREATE TABLE IF NOT EXISTS outbox_events (
+			id UUID PRIMARY KEY,
+			seq BIGSERIAL,
+			topic VARCHAR(255) NOT NULL,
+			event_key VARCHAR(255),
+			payload TEXT NOT NULL,
+			sent BOOLEAN DEFAULT FALSE,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP DEFAULT NOW(),
+			sent_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_events_unsent ON outbox_events (seq) WHERE sent = FALSE`,
+		`ALTER TABLE outbox_events
+			ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_events_sent ON outbox_events (sent_at) WHERE sent = TRUE`,
+		`CREATE TABLE IF NOT EXISTS processed_events (
+			event_id VARCHAR(255) PRIMARY KEY,
+			topic VARCHAR(255) NOT NULL,
+			processed_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS cancelled_orders (
+			order_id UUID PRIMARY KEY,
+			reason TEXT,
+			cancelled_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS base_rate DECIMAL(18,8)`,
+		`UPDATE payments SET base_rate = 1 WHERE base_rate IS NULL AND currency = 'USD'`,
+		`UPDATE payments p SET base_rate = r.rate FROM fx_rates r
+		 WHERE p.base_rate IS NULL AND r.base = p.currency AND r.quote = 'USD'`,
+		`UPDATE payments p SET base_rate = ROUND(1 / r.rate, 8) FROM fx_rates r
+		 WHERE p.base_rate IS NULL AND r.base = 'USD' AND r.quote = p.currency`,
+		`ALTER TABLE payments ADD COLUMN IF NOT EXISTS authorization_key VARCHAR(255)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_authorizing ON payments (created_at) WHERE status = 'AUTHORIZING'`,
 	}
 
 	for _, m := range migrations {
@@ -41,6 +224,70 @@ func InitDb(url string) *sql.DB {
 		}
 	}
 
+	if err := migrateUserBalances(db); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
 	log.Println("Payment DB initialized")
 	return db
 }
+
+// migrateUserBalances moves balances from the user_balances table, which the
+// ledger replaced, into ledger accounts funded by an opening entry, and then
+// drops the table. It does nothing once the table is gone.
+func migrateUserBalances(db *sql.DB) error {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('user_balances') IS NOT NULL`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check user_balances: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE user_balances`); err != nil {
+		return fmt.Errorf("failed to lock user_balances: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT user_id, balance FROM user_balances`)
+	if err != nil {
+		return fmt.Errorf("failed to read user_balances: %w", err)
+	}
+	balances := map[string]decimal.Decimal{}
+	for rows.Next() {
+		var userId string
+		var balance decimal.Decimal
+		if err := rows.Scan(&userId, &balance); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan user balance: %w", err)
+		}
+		balances[userId] = balance
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read user_balances: %w", err)
+	}
+
+	for userId, balance := range balances {
+		if _, err := ledger.OpenUserAccount(ctx, tx, userId, ledger.BaseCurrency, balance); err != nil {
+			return fmt.Errorf("failed to migrate balance of %s: %w", userId, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DROP TABLE user_balances`); err != nil {
+		return fmt.Errorf("failed to drop user_balances: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Migrated %d user balances to the ledger", len(balances))
+	return nil
+}
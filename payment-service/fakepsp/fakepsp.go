@@ -0,0 +1,225 @@
+// Package fakepsp is an in-memory payment service provider speaking the API
+// the card provider expects. It can be told to answer slowly, decline a share
+// of authorizations and time out a share of requests, so the behaviour around
+// an external provider can be exercised offline. Its state is lost on restart.
+package fakepsp
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"main.go/provider"
+)
+
+// Config controls how the fake PSP misbehaves.
+type Config struct {
+	// Latency is added to every request.
+	Latency time.Duration
+	// DeclineRate is the share of authorizations declined, from 0 to 1.
+	DeclineRate float64
+	// TimeoutRate is the share of requests that are applied but answered
+	// only after TimeoutDelay, which a client with a shorter timeout sees as
+	// a timeout with an unknown outcome.
+	TimeoutRate  float64
+	TimeoutDelay time.Duration
+	// AuthorizationTTL is how long an authorization can be captured.
+	AuthorizationTTL time.Duration
+	// Currencies lists the accepted currencies.
+	Currencies []string
+}
+
+var DefaultConfig = Config{
+	TimeoutDelay:     30 * time.Second,
+	AuthorizationTTL: 7 * 24 * time.Hour,
+	Currencies:       []string{"USD", "EUR", "GBP"},
+}
+
+// response is a recorded answer, replayed for a repeated idempotency key.
+type response struct {
+	status int
+	body   any
+}
+
+type Server struct {
+	config Config
+
+	mu             sync.Mutex
+	authorizations map[string]*provider.PSPAuthorization
+	responses      map[string]response
+}
+
+func NewServer(config Config) *Server {
+	return &Server{
+		config:         config,
+		authorizations: map[string]*provider.PSPAuthorization{},
+		responses:      map[string]response{},
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/authorizations", s.idempotent(s.authorize))
+	mux.HandleFunc("GET /v1/authorizations/{id}", s.get)
+	mux.HandleFunc("POST /v1/authorizations/{id}/capture", s.idempotent(s.capture))
+	mux.HandleFunc("POST /v1/authorizations/{id}/void", s.idempotent(s.void))
+	mux.HandleFunc("POST /v1/authorizations/{id}/refunds", s.idempotent(s.refund))
+	return mux
+}
+
+// ListenAndServe serves the fake PSP on addr until it fails.
+func ListenAndServe(addr string, config Config) error {
+	log.Printf("Fake PSP listening on %s (latency %s, decline rate %.2f, timeout rate %.2f)",
+		addr, config.Latency, config.DeclineRate, config.TimeoutRate)
+	return http.ListenAndServe(addr, NewServer(config).Handler())
+}
+
+// idempotent applies a mutating handler once per Idempotency-Key and replays
+// its response for repeated keys. It also adds the configured latency and
+// timeouts; a timed-out request is still applied, like a response lost on the
+// way back.
+func (s *Server) idempotent(handle func(r *http.Request) response) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(s.config.Latency)
+		key := r.Header.Get(provider.HeaderIdempotencyKey)
+		if key == "" {
+			respond(w, response{http.StatusBadRequest, provider.PSPError{Error: "Idempotency-Key header is required"}})
+			return
+		}
+
+		s.mu.Lock()
+		resp, seen := s.responses[key]
+		if !seen {
+			resp = handle(r)
+			s.responses[key] = resp
+		}
+		s.mu.Unlock()
+
+		if rand.Float64() < s.config.TimeoutRate {
+			log.Printf("Fake PSP: holding %s %s for %s", r.Method, r.URL.Path, s.config.TimeoutDelay)
+			time.Sleep(s.config.TimeoutDelay)
+		}
+		respond(w, resp)
+	}
+}
+
+func (s *Server) authorize(r *http.Request) response {
+	var req provider.AuthorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Amount.IsPositive() {
+		return response{http.StatusBadRequest, provider.PSPError{Error: "a positive amount is required"}}
+	}
+
+	auth := &provider.PSPAuthorization{
+		Id:        "auth_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Status:    provider.PSPAuthorized,
+		Amount:    req.Amount,
+		Currency:  strings.ToUpper(req.Currency),
+		ExpiresAt: time.Now().Add(s.config.AuthorizationTTL).UTC(),
+	}
+	switch {
+	case !s.accepts(auth.Currency):
+		auth.Status = provider.PSPDeclined
+		auth.DeclineCode = provider.CodeUnsupportedCurrency
+		auth.Message = "currency " + auth.Currency + " is not accepted"
+	case rand.Float64() < s.config.DeclineRate:
+		auth.Status = provider.PSPDeclined
+		auth.DeclineCode = provider.CodeCardDeclined
+		auth.Message = "card declined by issuer"
+	}
+	s.authorizations[auth.Id] = auth
+	log.Printf("Fake PSP: authorization %s of %s %s for order %s is %s", auth.Id, auth.Amount, auth.Currency, req.Reference, auth.Status)
+	return response{http.StatusOK, *auth}
+}
+
+func (s *Server) capture(r *http.Request) response {
+	auth, resp, ok := s.active(r)
+	if !ok {
+		return resp
+	}
+	auth.Status = provider.PSPCaptured
+	auth.Captured = auth.Amount
+	return response{http.StatusOK, *auth}
+}
+
+func (s *Server) void(r *http.Request) response {
+	auth, resp, ok := s.active(r)
+	if !ok {
+		return resp
+	}
+	auth.Status = provider.PSPVoided
+	return response{http.StatusOK, *auth}
+}
+
+func (s *Server) refund(r *http.Request) response {
+	auth, ok := s.authorizations[r.PathValue("id")]
+	if !ok {
+		return response{http.StatusNotFound, provider.PSPError{Error: "authorization not found"}}
+	}
+	var req provider.RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Amount.IsPositive() {
+		return response{http.StatusBadRequest, provider.PSPError{Error: "a positive amount is required"}}
+	}
+	if auth.Status != provider.PSPCaptured {
+		return response{http.StatusConflict, provider.PSPError{Error: "authorization is " + auth.Status}}
+	}
+	if auth.Refunded.Add(req.Amount).GreaterThan(auth.Captured) {
+		return response{http.StatusConflict, provider.PSPError{Error: "refund exceeds the captured amount"}}
+	}
+	auth.Refunded = auth.Refunded.Add(req.Amount)
+	return response{http.StatusOK, *auth}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.config.Latency)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth, ok := s.authorizations[r.PathValue("id")]
+	if !ok {
+		respond(w, response{http.StatusNotFound, provider.PSPError{Error: "authorization not found"}})
+		return
+	}
+	s.expire(auth)
+	respond(w, response{http.StatusOK, *auth})
+}
+
+// active returns the authorization of the request if it can still be
+// captured or voided.
+func (s *Server) active(r *http.Request) (*provider.PSPAuthorization, response, bool) {
+	auth, ok := s.authorizations[r.PathValue("id")]
+	if !ok {
+		return nil, response{http.StatusNotFound, provider.PSPError{Error: "authorization not found"}}, false
+	}
+	s.expire(auth)
+	if auth.Status != provider.PSPAuthorized {
+		return nil, response{http.StatusConflict, provider.PSPError{Error: "authorization is " + auth.Status}}, false
+	}
+	return auth, response{}, true
+}
+
+// expire voids an authorization that was not captured in time.
+func (s *Server) expire(auth *provider.PSPAuthorization) {
+	if auth.Status == provider.PSPAuthorized && time.Now().After(auth.ExpiresAt) {
+		auth.Status = provider.PSPVoided
+	}
+}
+
+func (s *Server) accepts(currency string) bool {
+	for _, c := range s.config.Currencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
+
+func respond(w http.ResponseWriter, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	json.NewEncoder(w).Encode(resp.body)
+}
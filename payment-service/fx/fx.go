@@ -0,0 +1,127 @@
+// Package fx converts amounts between currencies with the exchange rates kept
+// in the fx_rates table.
+package fx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Base is the currency cross rates are derived through when a pair has no
+// rate of its own.
+const Base = "USD"
+
+// rateScale is the number of decimal places rates are kept with.
+const rateScale = 8
+
+var ErrNoRate = errors.New("no exchange rate")
+
+// Rate is how many units of Quote one unit of Base buys.
+type Rate struct {
+	Base      string
+	Quote     string
+	Rate      decimal.Decimal
+	UpdatedAt time.Time
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// GetRate returns how many units of quote one unit of base buys. A pair
+// without a rate of its own uses the inverse of the opposite pair, or else a
+// cross rate through Base.
+func GetRate(ctx context.Context, q querier, base string, quote string) (decimal.Decimal, error) {
+	if base == quote {
+		return decimal.NewFromInt(1), nil
+	}
+
+	rate, err := pairRate(ctx, q, base, quote)
+	if !errors.Is(err, ErrNoRate) || base == Base || quote == Base {
+		return rate, err
+	}
+
+	toBase, err := pairRate(ctx, q, base, Base)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: %s/%s", ErrNoRate, base, quote)
+	}
+	fromBase, err := pairRate(ctx, q, Base, quote)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: %s/%s", ErrNoRate, base, quote)
+	}
+	return toBase.Mul(fromBase).Round(rateScale), nil
+}
+
+// pairRate returns the rate of a pair or the inverse of the opposite pair.
+func pairRate(ctx context.Context, q querier, base string, quote string) (decimal.Decimal, error) {
+	var rate decimal.Decimal
+	var inverse bool
+	err := q.QueryRowContext(ctx,
+		`SELECT rate, base <> $1 FROM fx_rates
+		 WHERE (base = $1 AND quote = $2) OR (base = $2 AND quote = $1)
+		 ORDER BY base = $1 DESC
+		 LIMIT 1`,
+		base, quote,
+	).Scan(&rate, &inverse)
+	if errors.Is(err, sql.ErrNoRows) {
+		return decimal.Zero, fmt.Errorf("%w: %s/%s", ErrNoRate, base, quote)
+	}
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get exchange rate: %w", err)
+	}
+	if inverse {
+		return decimal.NewFromInt(1).DivRound(rate, rateScale), nil
+	}
+	return rate, nil
+}
+
+// Convert converts amount from one currency to another, rounded to cents,
+// and returns it together with the rate applied.
+func Convert(ctx context.Context, q querier, amount decimal.Decimal, from string, to string) (decimal.Decimal, decimal.Decimal, error) {
+	rate, err := GetRate(ctx, q, from, to)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	return amount.Mul(rate).Round(2), rate, nil
+}
+
+// SetRate creates or replaces the rate of a pair.
+func SetRate(ctx context.Context, db *sql.DB, base string, quote string, rate decimal.Decimal) (Rate, error) {
+	r := Rate{Base: base, Quote: quote, Rate: rate.Round(rateScale), UpdatedAt: time.Now()}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO fx_rates (base, quote, rate, updated_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (base, quote) DO UPDATE SET rate = EXCLUDED.rate, updated_at = EXCLUDED.updated_at`,
+		r.Base, r.Quote, r.Rate, r.UpdatedAt,
+	)
+	if err != nil {
+		return Rate{}, fmt.Errorf("failed to set exchange rate: %w", err)
+	}
+	return r, nil
+}
+
+// List returns every rate, ordered by pair.
+func List(ctx context.Context, db *sql.DB) ([]Rate, error) {
+	rows, err := db.QueryContext(ctx, `SELECT base, quote, rate, updated_at FROM fx_rates ORDER BY base, quote`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []Rate
+	for rows.Next() {
+		var r Rate
+		if err := rows.Scan(&r.Base, &r.Quote, &r.Rate, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan exchange rate: %w", err)
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list exchange rates: %w", err)
+	}
+	return rates, nil
+}
@@ -8,17 +8,28 @@ import (
 	"log"
 	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/inbox"
+	"github.com/5rfy/micro-delivery/pkg/retry"
 	"github.com/5rfy/micro-delivery/proto/generated/payment"
 	"github.com/IBM/sarama"
 	"main.go/service"
 	"main.go/structs"
 )
 
-const TopicOrderCreated = "order.created"
+const TopicPaymentCommands = "payment.commands"
+
+const (
+	CommandProcessPayment = "PROCESS_PAYMENT"
+	CommandRefundPayment  = "REFUND_PAYMENT"
+	CommandCapturePayment = "CAPTURE_PAYMENT"
+)
+
+const consumerGroup = "payment-service-group"
 
 type ConsumerHandler struct {
-	db       *sql.DB
-	producer sarama.SyncProducer
+	db     *sql.DB
+	server *service.Server
+	retry  *retry.Retrier
 }
 
 func (h *ConsumerHandler) Setup(_ sarama.ConsumerGroupSession) error {
@@ -29,46 +40,99 @@ func (h *ConsumerHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
 }
 
 func (h *ConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		log.Printf("Payment service received order.created event")
-
-		var event structs.OrderCreatedEvent
-		if err := json.Unmarshal(msg.Value, &event); err != nil {
-			log.Printf("Failed to unmarshal: %v", err)
-			session.MarkMessage(msg, "")
-			continue
+	return h.retry.Consume(session, claim, h.handle)
+}
+
+func (h *ConsumerHandler) handle(msg *sarama.ConsumerMessage) error {
+	log.Printf("Payment service received %s message", retry.SourceTopic(msg))
+
+	switch retry.SourceTopic(msg) {
+	case TopicPaymentCommands:
+		return h.handleCommand(msg)
+	}
+	return nil
+}
+
+// handleCommand executes a command sent by the order saga. Commands are
+// deduplicated by their command id, so a command re-sent after a timeout is
+// applied at most once. A payment left for a remote provider to authorize is
+// settled once the command is committed; if that fails, the
+// AuthorizationWorker settles it later.
+func (h *ConsumerHandler) handleCommand(msg *sarama.ConsumerMessage) error {
+	var command structs.PaymentCommand
+	if err := json.Unmarshal(msg.Value, &command); err != nil {
+		return retry.Permanent(fmt.Errorf("failed to unmarshal: %w", err))
+	}
+
+	id := command.CommandId
+	if id == "" {
+		id = inbox.EventId(msg)
+	}
+
+	ctx := context.Background()
+	var paid *payment.ProcessPaymentResponse
+	processed, err := inbox.Handle(ctx, h.db, id, TopicPaymentCommands, func(tx *sql.Tx) error {
+		switch command.Type {
+		case CommandProcessPayment:
+			var err error
+			paid, err = h.server.ProcessPaymentTx(ctx, tx, &payment.ProcessPaymentRequest{
+				OrderId:         command.OrderId,
+				UserId:          command.UserId,
+				Amount:          command.Amount.InexactFloat64(),
+				Currency:        command.Currency,
+				Provider:        command.PaymentMethod,
+				DeliveryAddress: command.DeliveryAddress,
+			})
+			return err
+		case CommandRefundPayment:
+			return h.server.RefundOrderTx(ctx, tx, command.OrderId, command.Reason)
+		case CommandCapturePayment:
+			return h.server.CapturePaymentTx(ctx, tx, command.OrderId)
 		}
+		return retry.Permanent(fmt.Errorf("unknown command type %q", command.Type))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute %s: %w", command.Type, err)
+	}
+	if !processed {
+		log.Printf("Skipping duplicate %s command for order %s", command.Type, command.OrderId)
+		return nil
+	}
+	log.Printf("Executed %s command for order %s", command.Type, command.OrderId)
 
-		s := service.NewServer(h.db, h.producer)
-		_, err := s.ProcessPayment(context.Background(), &payment.ProcessPaymentRequest{
-			OrderId:  event.OrderId,
-			UserId:   event.UserId,
-			Amount:   event.TotalAmount.InexactFloat64(),
-			Currency: "USD"},
-		)
-		if err != nil {
-			return fmt.Errorf("failed to process: %v", err)
+	if paid != nil && paid.Status == service.StatusAuthorizing {
+		if _, err := h.server.SettleAuthorization(ctx, paid.PaymentId); err != nil {
+			log.Printf("Failed to settle authorization of payment %s, retrying later: %v", paid.PaymentId, err)
 		}
-		session.MarkMessage(msg, "")
 	}
 	return nil
 }
 
-func StartConsumer(db *sql.DB, producer sarama.SyncProducer, brokers []string) {
+func StartConsumer(db *sql.DB, server *service.Server, producer sarama.SyncProducer, brokers []string) {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
 
-	group, err := sarama.NewConsumerGroup(brokers, "payment-service-group", config)
+	group, err := sarama.NewConsumerGroup(brokers, consumerGroup, config)
 	if err != nil {
 		log.Fatalf("Failed to create consumer group: %v", err)
 	}
 
-	handler := &ConsumerHandler{db: db, producer: producer}
+	handler := &ConsumerHandler{
+		db:     db,
+		server: server,
+		retry:  retry.NewRetrier(producer, retry.DefaultPolicy),
+	}
+	topics := retry.DefaultPolicy.Topics(TopicPaymentCommands)
 
 	for {
-		if err := group.Consume(context.Background(), []string{TopicOrderCreated}, handler); err != nil {
+		if err := group.Consume(context.Background(), topics, handler); err != nil {
 			log.Printf("Consumer error: %v", err)
 			time.Sleep(5 * time.Second)
 		}
 	}
 }
+
+// RedriveDLQ publishes everything parked on <topic>.dlq back to <topic>.
+func RedriveDLQ(brokers []string, topic string) error {
+	return retry.RedriveDLQ(brokers, consumerGroup, topic)
+}
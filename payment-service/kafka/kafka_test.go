@@ -0,0 +1,121 @@
+package kafka
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/retry"
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"main.go/database"
+	"main.go/limits"
+	"main.go/provider"
+	"main.go/risk"
+	"main.go/service"
+	"main.go/structs"
+)
+
+func newHandler(t *testing.T) *ConsumerHandler {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+
+	wallet := service.DefaultWalletPolicy
+	providers := provider.Registry{
+		provider.Wallet: provider.NewWallet(db, wallet.InitialBalance, wallet.HoldTTL),
+	}
+	server := service.NewServer(db, wallet, providers, risk.NewEngine(risk.Rules{}), limits.NewChecker(limits.Limits{}))
+	return &ConsumerHandler{db: db, server: server}
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...any) int {
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	return n
+}
+
+// replays returns the ways one command reaches the consumer again: the same
+// message redelivered after a rebalance, the saga re-sending it after a
+// timeout, and a copy coming back from a retry topic.
+func replays(t *testing.T, command structs.PaymentCommand) []*sarama.ConsumerMessage {
+	value, err := json.Marshal(command)
+	if err != nil {
+		t.Fatalf("failed to marshal command: %v", err)
+	}
+	return []*sarama.ConsumerMessage{
+		{Topic: TopicPaymentCommands, Offset: 7, Value: value},
+		{Topic: TopicPaymentCommands, Offset: 7, Value: value},
+		{Topic: TopicPaymentCommands, Offset: 12, Value: value},
+		{Topic: TopicPaymentCommands + ".retry.1", Offset: 3, Value: value, Headers: []*sarama.RecordHeader{
+			{Key: []byte(retry.HeaderOriginalTopic), Value: []byte(TopicPaymentCommands)},
+		}},
+	}
+}
+
+func TestReplayedPaymentCommandChargesOnce(t *testing.T) {
+	h := newHandler(t)
+	orderId := uuid.New().String()
+	command := structs.PaymentCommand{
+		CommandId: orderId + ":PAYMENT",
+		Type:      CommandProcessPayment,
+		OrderId:   orderId,
+		UserId:    "user-1",
+		Amount:    decimal.NewFromInt(40),
+		Currency:  "USD",
+	}
+
+	for i, msg := range replays(t, command) {
+		if err := h.handle(msg); err != nil {
+			t.Fatalf("delivery %d: %v", i, err)
+		}
+	}
+
+	if n := count(t, h.db, `SELECT COUNT(*) FROM payments WHERE order_id = $1`, orderId); n != 1 {
+		t.Fatalf("payments = %d, want 1", n)
+	}
+	if n := count(t, h.db, `SELECT COUNT(*) FROM holds WHERE status = 'ACTIVE'`); n != 1 {
+		t.Fatalf("active holds = %d, want 1", n)
+	}
+	if n := count(t, h.db, `SELECT COUNT(*) FROM outbox_events WHERE topic = $1`, service.TopicPaymentCompleted); n != 1 {
+		t.Fatalf("payment.completed events = %d, want 1", n)
+	}
+}
+
+func TestReplayedRefundCommandRefundsOnce(t *testing.T) {
+	h := newHandler(t)
+	orderId := uuid.New().String()
+	pay := structs.PaymentCommand{
+		CommandId: orderId + ":PAYMENT",
+		Type:      CommandProcessPayment,
+		OrderId:   orderId,
+		UserId:    "user-1",
+		Amount:    decimal.NewFromInt(40),
+		Currency:  "USD",
+	}
+	if err := h.handle(replays(t, pay)[0]); err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+
+	refund := structs.PaymentCommand{
+		CommandId: orderId + ":REFUND_PAYMENT",
+		Type:      CommandRefundPayment,
+		OrderId:   orderId,
+		Reason:    "order cancelled",
+	}
+	for i, msg := range replays(t, refund) {
+		if err := h.handle(msg); err != nil {
+			t.Fatalf("delivery %d: %v", i, err)
+		}
+	}
+
+	if n := count(t, h.db, `SELECT COUNT(*) FROM outbox_events WHERE topic = $1`, service.TopicPaymentRefunded); n != 1 {
+		t.Fatalf("payment.refunded events = %d, want 1", n)
+	}
+	if n := count(t, h.db, `SELECT COUNT(*) FROM holds WHERE status = 'ACTIVE'`); n != 0 {
+		t.Fatalf("active holds = %d, want 0", n)
+	}
+}
@@ -0,0 +1,105 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Hold statuses.
+const (
+	HoldActive   = "ACTIVE"
+	HoldCaptured = "CAPTURED"
+	HoldReleased = "RELEASED"
+)
+
+// PlaceHold reserves amount on a user account inside tx. A hold is not a
+// journal entry: it lowers the available balance but leaves the ledger
+// balance alone until it is captured. It fails with ErrInsufficientFunds if
+// the available balance does not cover amount.
+func PlaceHold(ctx context.Context, tx *sql.Tx, accountId string, reference string, amount decimal.Decimal, expiresAt time.Time) (string, error) {
+	if !amount.IsPositive() {
+		return "", fmt.Errorf("hold on %s must have a positive amount", accountId)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE accounts SET held = held + $1 WHERE id = $2 AND balance - held >= $1`,
+		amount, accountId,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to place hold: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("failed to place hold: %w", err)
+	}
+	if updated == 0 {
+		if _, _, err := Available(ctx, tx, accountId); err != nil {
+			return "", err
+		}
+		return "", ErrInsufficientFunds
+	}
+
+	holdId := uuid.New().String()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO holds (id, account_id, reference, amount, status, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		holdId, accountId, reference, amount, HoldActive, expiresAt, time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert hold: %w", err)
+	}
+	return holdId, nil
+}
+
+// ReleaseHold gives an active hold back to the available balance, ending it
+// with the given status.
+func ReleaseHold(ctx context.Context, tx *sql.Tx, holdId string, status string) error {
+	_, _, err := settle(ctx, tx, holdId, status)
+	return err
+}
+
+// CaptureHold turns an active hold into a journal entry moving the held
+// amount from the user account to counterparty, and returns the entry id.
+func CaptureHold(ctx context.Context, tx *sql.Tx, holdId string, counterparty string, entryType string, reference string, description string) (string, error) {
+	accountId, amount, err := settle(ctx, tx, holdId, HoldCaptured)
+	if err != nil {
+		return "", err
+	}
+	return Post(ctx, tx, entryType, reference, description,
+		Posting{AccountId: accountId, Amount: amount.Neg()},
+		Posting{AccountId: counterparty, Amount: amount},
+	)
+}
+
+// settle ends an active hold and removes its amount from the account's held total.
+func settle(ctx context.Context, tx *sql.Tx, holdId string, status string) (string, decimal.Decimal, error) {
+	var accountId string
+	var amount decimal.Decimal
+	err := tx.QueryRowContext(ctx,
+		`UPDATE holds SET status = $1, settled_at = $2
+		 WHERE id = $3 AND status = $4
+		 RETURNING account_id, amount`,
+		status, time.Now(), holdId, HoldActive,
+	).Scan(&accountId, &amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", decimal.Zero, ErrHoldNotActive
+	}
+	if err != nil {
+		return "", decimal.Zero, fmt.Errorf("failed to settle hold: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE accounts SET held = held - $1 WHERE id = $2`,
+		amount, accountId,
+	)
+	if err != nil {
+		return "", decimal.Zero, fmt.Errorf("failed to release held amount: %w", err)
+	}
+	return accountId, amount, nil
+}
@@ -0,0 +1,375 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Journal entry types.
+const (
+	EntryOpening = "OPENING"
+	EntryPayment = "PAYMENT"
+	EntryRefund  = "REFUND"
+	EntryDeposit = "DEPOSIT"
+)
+
+// System accounts. Opening is the counterpart of opening balances granted to
+// users, revenue collects what users pay for their orders, and deposits is
+// the counterpart of money users top up their wallets with. These are the
+// accounts in BaseCurrency; SystemAccount names their siblings in other
+// currencies.
+const (
+	AccountOpening  = "system:opening"
+	AccountRevenue  = "system:revenue"
+	AccountDeposits = "system:deposits"
+)
+
+var systemAccounts = []string{AccountOpening, AccountRevenue, AccountDeposits}
+
+// BaseCurrency is the currency of accounts whose id has no currency suffix,
+// which includes every account opened before wallets had a currency.
+const BaseCurrency = "USD"
+
+const (
+	KindUser   = "USER"
+	KindSystem = "SYSTEM"
+)
+
+const userAccountPrefix = "user:"
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrAccountNotFound   = errors.New("account not found")
+	ErrUnbalancedEntry   = errors.New("postings do not sum to zero")
+	ErrHoldNotActive     = errors.New("hold is not active")
+	ErrMixedCurrencies   = errors.New("postings are in different currencies")
+)
+
+// Posting moves amount into an account; a negative amount moves it out.
+type Posting struct {
+	AccountId string
+	Amount    decimal.Decimal
+}
+
+// UserAccount returns the id of a user's wallet account in currency. A user
+// has one wallet per currency.
+func UserAccount(userId string, currency string) string {
+	return withCurrency(userAccountPrefix+userId, currency)
+}
+
+// SystemAccount returns the id of a system account in currency.
+func SystemAccount(account string, currency string) string {
+	return withCurrency(account, currency)
+}
+
+func withCurrency(accountId string, currency string) string {
+	if currency == BaseCurrency {
+		return accountId
+	}
+	return accountId + ":" + currency
+}
+
+func isUserAccount(accountId string) bool {
+	return strings.HasPrefix(accountId, userAccountPrefix)
+}
+
+// OpenUserAccount creates a user's wallet account in currency inside tx,
+// funded with an OPENING entry when opening is positive, together with the
+// system accounts of that currency. It reports whether the account was
+// created; an existing account is left as it is.
+func OpenUserAccount(ctx context.Context, tx *sql.Tx, userId string, currency string, opening decimal.Decimal) (bool, error) {
+	if currency != BaseCurrency {
+		for _, account := range systemAccounts {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO accounts (id, kind, currency, created_at) VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (id) DO NOTHING`,
+				SystemAccount(account, currency), KindSystem, currency, time.Now(),
+			)
+			if err != nil {
+				return false, fmt.Errorf("failed to open system account: %w", err)
+			}
+		}
+	}
+
+	accountId := UserAccount(userId, currency)
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO accounts (id, kind, user_id, currency, balance, created_at) VALUES ($1, $2, $3, $4, 0, $5)
+		 ON CONFLICT (id) DO NOTHING`,
+		accountId, KindUser, userId, currency, time.Now(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to open account: %w", err)
+	}
+	created, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to open account: %w", err)
+	}
+	if created == 0 {
+		return false, nil
+	}
+
+	if opening.IsPositive() {
+		_, err = Post(ctx, tx, EntryOpening, "", "opening balance",
+			Posting{AccountId: SystemAccount(AccountOpening, currency), Amount: opening.Neg()},
+			Posting{AccountId: accountId, Amount: opening},
+		)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Post records a journal entry with its postings inside tx and returns the
+// entry id. The postings must be in one currency and sum to zero, which the
+// database enforces as well when the transaction commits. Entries are never
+// updated or deleted; a mistake is corrected by posting the reverse entry.
+//
+// User account balances are materialized in accounts.balance and updated
+// with a conditional UPDATE, so concurrent entries serialize on the user's
+// row and the available balance (balance minus holds) cannot go negative:
+// such an entry fails with ErrInsufficientFunds and leaves tx as it was.
+// System account balances are summed from postings instead, so hot accounts
+// like revenue are never locked.
+func Post(ctx context.Context, tx *sql.Tx, entryType string, reference string, description string, postings ...Posting) (string, error) {
+	if len(postings) < 2 {
+		return "", fmt.Errorf("%w: an entry needs at least two postings", ErrUnbalancedEntry)
+	}
+	sum := decimal.Zero
+	for _, p := range postings {
+		if p.Amount.IsZero() {
+			return "", fmt.Errorf("posting to %s has a zero amount", p.AccountId)
+		}
+		sum = sum.Add(p.Amount)
+	}
+	if !sum.IsZero() {
+		return "", fmt.Errorf("%w: sum is %s", ErrUnbalancedEntry, sum)
+	}
+
+	// Lock user rows in a fixed order so entries touching the same accounts
+	// cannot deadlock.
+	ordered := append([]Posting(nil), postings...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].AccountId < ordered[j].AccountId })
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT ledger_post`); err != nil {
+		return "", fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	entryId, err := post(ctx, tx, entryType, reference, description, ordered)
+	if err != nil {
+		if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT ledger_post`); rbErr != nil {
+			return "", fmt.Errorf("failed to roll back to savepoint: %w", rbErr)
+		}
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT ledger_post`); err != nil {
+		return "", fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return entryId, nil
+}
+
+func post(ctx context.Context, tx *sql.Tx, entryType string, reference string, description string, postings []Posting) (string, error) {
+	if err := checkCurrency(ctx, tx, postings); err != nil {
+		return "", err
+	}
+
+	entryId := uuid.New().String()
+	now := time.Now()
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO journal_entries (id, type, reference, description, created_at)
+		 VALUES ($1, $2, NULLIF($3, ''), $4, $5)`,
+		entryId, entryType, reference, description, now,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+
+	for _, p := range postings {
+		var balanceAfter decimal.NullDecimal
+		if isUserAccount(p.AccountId) {
+			balance, err := apply(ctx, tx, p)
+			if err != nil {
+				return "", err
+			}
+			balanceAfter = decimal.NewNullDecimal(balance)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO postings (entry_id, account_id, amount, balance_after, created_at)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			entryId, p.AccountId, p.Amount, balanceAfter, now,
+		)
+		if err != nil {
+			return "", fmt.Errorf("failed to insert posting: %w", err)
+		}
+	}
+	return entryId, nil
+}
+
+// checkCurrency makes sure the accounts of an entry's postings share one
+// currency, since amounts in different currencies cannot balance each other.
+func checkCurrency(ctx context.Context, tx *sql.Tx, postings []Posting) error {
+	placeholders := make([]string, len(postings))
+	args := make([]any, len(postings))
+	for i, p := range postings {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = p.AccountId
+	}
+
+	var currencies int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(DISTINCT currency) FROM accounts WHERE id IN (`+strings.Join(placeholders, ", ")+`)`,
+		args...,
+	).Scan(&currencies)
+	if err != nil {
+		return fmt.Errorf("failed to check currencies: %w", err)
+	}
+	if currencies > 1 {
+		return ErrMixedCurrencies
+	}
+	return nil
+}
+
+// apply adds a posting to a user account's materialized balance.
+func apply(ctx context.Context, tx *sql.Tx, p Posting) (decimal.Decimal, error) {
+	var balance decimal.Decimal
+	err := tx.QueryRowContext(ctx,
+		`UPDATE accounts SET balance = balance + $1
+		 WHERE id = $2 AND balance + $1 >= held
+		 RETURNING balance`,
+		p.Amount, p.AccountId,
+	).Scan(&balance)
+	if err == nil {
+		return balance, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return decimal.Zero, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	exists, err := AccountExists(ctx, tx, p.AccountId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !exists {
+		return decimal.Zero, fmt.Errorf("%w: %s", ErrAccountNotFound, p.AccountId)
+	}
+	return decimal.Zero, ErrInsufficientFunds
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Balance returns the current balance of an account.
+func Balance(ctx context.Context, q querier, accountId string) (decimal.Decimal, error) {
+	var balance decimal.Decimal
+	var err error
+	if isUserAccount(accountId) {
+		err = q.QueryRowContext(ctx, `SELECT balance FROM accounts WHERE id = $1`, accountId).Scan(&balance)
+	} else {
+		err = q.QueryRowContext(ctx,
+			`SELECT COALESCE(SUM(p.amount), 0) FROM accounts a
+			 LEFT JOIN postings p ON p.account_id = a.id
+			 WHERE a.id = $1
+			 GROUP BY a.id`,
+			accountId,
+		).Scan(&balance)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return decimal.Zero, fmt.Errorf("%w: %s", ErrAccountNotFound, accountId)
+	}
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get balance: %w", err)
+	}
+	return balance, nil
+}
+
+// AccountExists reports whether an account was opened.
+func AccountExists(ctx context.Context, q querier, accountId string) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM accounts WHERE id = $1)`, accountId).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check account: %w", err)
+	}
+	return exists, nil
+}
+
+// Available returns the balance of a user account minus its active holds.
+func Available(ctx context.Context, q querier, accountId string) (decimal.Decimal, decimal.Decimal, error) {
+	var balance, held decimal.Decimal
+	err := q.QueryRowContext(ctx, `SELECT balance, held FROM accounts WHERE id = $1`, accountId).Scan(&balance, &held)
+	if errors.Is(err, sql.ErrNoRows) {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("%w: %s", ErrAccountNotFound, accountId)
+	}
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("failed to get balance: %w", err)
+	}
+	return balance.Sub(held), held, nil
+}
+
+// Line is one posting on a user's statement together with its entry.
+type Line struct {
+	PostingId    int64
+	EntryId      string
+	Type         string
+	Reference    string
+	Description  string
+	Amount       decimal.Decimal
+	BalanceAfter decimal.Decimal
+	CreatedAt    time.Time
+}
+
+// Statement returns up to limit postings of a user account, newest first,
+// starting below posting id before (0 starts from the newest). A non-empty
+// types restricts the postings to entries of those types.
+func Statement(ctx context.Context, db *sql.DB, accountId string, types []string, before int64, limit int) ([]Line, error) {
+	query := `SELECT p.id, e.id, e.type, COALESCE(e.reference, ''), COALESCE(e.description, ''),
+		        p.amount, p.balance_after, p.created_at
+		 FROM postings p
+		 JOIN journal_entries e ON e.id = p.entry_id
+		 WHERE p.account_id = $1`
+	args := []any{accountId}
+
+	if len(types) > 0 {
+		placeholders := make([]string, len(types))
+		for i, t := range types {
+			args = append(args, t)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		query += " AND e.type IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	if before > 0 {
+		args = append(args, before)
+		query += fmt.Sprintf(" AND p.id < $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY p.id DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statement: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []Line
+	for rows.Next() {
+		var l Line
+		if err := rows.Scan(&l.PostingId, &l.EntryId, &l.Type, &l.Reference, &l.Description,
+			&l.Amount, &l.BalanceAfter, &l.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan statement line: %w", err)
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get statement: %w", err)
+	}
+	return lines, nil
+}
@@ -0,0 +1,148 @@
+package ledger_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/shopspring/decimal"
+	"main.go/database"
+	"main.go/ledger"
+)
+
+const (
+	payments = 40
+	currency = ledger.BaseCurrency
+)
+
+var (
+	opening = decimal.NewFromInt(100)
+	amount  = decimal.NewFromInt(7)
+)
+
+// openAccount returns a database with user-1's wallet funded with opening.
+func openAccount(t *testing.T) (*sql.DB, string) {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := ledger.OpenUserAccount(ctx, tx, "user-1", currency, opening); err != nil {
+		t.Fatalf("failed to open account: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	return db, ledger.UserAccount("user-1", currency)
+}
+
+// race runs pay in payments transactions at once and returns how many of
+// them committed. A payment may only fail with ErrInsufficientFunds.
+func race(t *testing.T, db *sql.DB, pay func(ctx context.Context, tx *sql.Tx, i int) error) int {
+	ctx := context.Background()
+	start := make(chan struct{})
+	results := make(chan error, payments)
+
+	var wg sync.WaitGroup
+	for i := 0; i < payments; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				results <- err
+				return
+			}
+			defer tx.Rollback()
+			if err := pay(ctx, tx, i); err != nil {
+				results <- err
+				return
+			}
+			results <- tx.Commit()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ledger.ErrInsufficientFunds):
+			t.Fatalf("payment failed: %v", err)
+		}
+	}
+	return succeeded
+}
+
+func TestConcurrentPaymentsNeverOverdraw(t *testing.T) {
+	db, account := openAccount(t)
+
+	succeeded := race(t, db, func(ctx context.Context, tx *sql.Tx, i int) error {
+		_, err := ledger.Post(ctx, tx, ledger.EntryPayment, fmt.Sprintf("order-%d", i), "payment",
+			ledger.Posting{AccountId: account, Amount: amount.Neg()},
+			ledger.Posting{AccountId: ledger.SystemAccount(ledger.AccountRevenue, currency), Amount: amount},
+		)
+		return err
+	})
+
+	want := int(opening.Div(amount).IntPart())
+	if succeeded != want {
+		t.Fatalf("%d payments succeeded, want %d", succeeded, want)
+	}
+
+	ctx := context.Background()
+	balance, err := ledger.Balance(ctx, db, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantBalance := opening.Sub(amount.Mul(decimal.NewFromInt(int64(want)))); !balance.Equal(wantBalance) {
+		t.Fatalf("balance = %s, want %s", balance, wantBalance)
+	}
+
+	var lowest decimal.Decimal
+	err = db.QueryRow(`SELECT MIN(balance_after) FROM postings WHERE account_id = $1`, account).Scan(&lowest)
+	if err != nil {
+		t.Fatalf("failed to get lowest balance: %v", err)
+	}
+	if lowest.IsNegative() {
+		t.Fatalf("balance went down to %s", lowest)
+	}
+}
+
+func TestConcurrentHoldsNeverOverdraw(t *testing.T) {
+	db, account := openAccount(t)
+
+	succeeded := race(t, db, func(ctx context.Context, tx *sql.Tx, i int) error {
+		_, err := ledger.PlaceHold(ctx, tx, account, fmt.Sprintf("payment-%d", i), amount, time.Now().Add(time.Hour))
+		return err
+	})
+
+	want := int(opening.Div(amount).IntPart())
+	if succeeded != want {
+		t.Fatalf("%d holds succeeded, want %d", succeeded, want)
+	}
+
+	available, held, err := ledger.Available(context.Background(), db, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available.IsNegative() {
+		t.Fatalf("available balance is %s", available)
+	}
+	if wantHeld := amount.Mul(decimal.NewFromInt(int64(want))); !held.Equal(wantHeld) {
+		t.Fatalf("held = %s, want %s", held, wantHeld)
+	}
+}
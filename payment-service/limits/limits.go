@@ -0,0 +1,144 @@
+// Package limits caps how much a user may spend on one order and within a
+// rolling day. Global defaults apply to every user unless the
+// spending_limits table overrides them for that user.
+package limits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"main.go/fx"
+)
+
+// Window is the rolling period the daily limit applies to.
+const Window = 24 * time.Hour
+
+var ErrExceeded = errors.New("spending limit exceeded")
+
+// Limits are amounts in fx.Base. A zero limit is no limit.
+type Limits struct {
+	PerOrder decimal.Decimal
+	Daily    decimal.Decimal
+}
+
+var DefaultLimits = Limits{
+	PerOrder: decimal.NewFromInt(10000),
+	Daily:    decimal.NewFromInt(25000),
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type Checker struct {
+	defaults Limits
+}
+
+func NewChecker(defaults Limits) *Checker {
+	return &Checker{defaults: defaults}
+}
+
+// Effective returns the limits of a user, and whether any of them is
+// overridden for the user rather than the default.
+func (c *Checker) Effective(ctx context.Context, q querier, userId string) (Limits, bool, error) {
+	var perOrder, daily decimal.NullDecimal
+	err := q.QueryRowContext(ctx,
+		`SELECT per_order_limit, daily_limit FROM spending_limits WHERE user_id = $1`,
+		userId,
+	).Scan(&perOrder, &daily)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.defaults, false, nil
+	}
+	if err != nil {
+		return Limits{}, false, fmt.Errorf("failed to get spending limits: %w", err)
+	}
+
+	limits := c.defaults
+	if perOrder.Valid {
+		limits.PerOrder = perOrder.Decimal
+	}
+	if daily.Valid {
+		limits.Daily = daily.Decimal
+	}
+	return limits, perOrder.Valid || daily.Valid, nil
+}
+
+// Spent returns what a user spent within the last Window, in fx.Base. It
+// counts payments that went through, less their refunds, and payments held
+// for review or waiting for their provider, which may still go through. Each payment counts at the rate to
+// fx.Base stored when it was made, so later rate changes do not move it.
+func Spent(ctx context.Context, q querier, userId string) (decimal.Decimal, error) {
+	var spent decimal.Decimal
+	err := q.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM((amount - refunded_amount) * base_rate), 0) FROM payments
+		 WHERE user_id = $1 AND created_at > $2 AND base_rate IS NOT NULL
+		   AND status IN ('REVIEW', 'AUTHORIZING', 'AUTHORIZED', 'SUCCESS', 'PARTIALLY_REFUNDED', 'REFUNDED')`,
+		userId, time.Now().Add(-Window),
+	).Scan(&spent)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum spending: %w", err)
+	}
+	return spent.Round(2), nil
+}
+
+// Check fails with ErrExceeded if a payment of amount in currency would take
+// the user over one of their limits. It locks the user's spending until tx
+// ends, so concurrent payments of one user are checked one after another
+// and cannot overshoot the daily limit together.
+func (c *Checker) Check(ctx context.Context, tx *sql.Tx, userId string, amount decimal.Decimal, currency string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('spending:' || $1))`, userId); err != nil {
+		return fmt.Errorf("failed to lock spending: %w", err)
+	}
+
+	limits, _, err := c.Effective(ctx, tx, userId)
+	if err != nil {
+		return err
+	}
+	amount, _, err = fx.Convert(ctx, tx, amount, currency, fx.Base)
+	if err != nil {
+		return err
+	}
+
+	if limits.PerOrder.IsPositive() && amount.GreaterThan(limits.PerOrder) {
+		return fmt.Errorf("%w: %s %s exceeds the per-order limit of %s %s",
+			ErrExceeded, amount, fx.Base, limits.PerOrder, fx.Base)
+	}
+	if limits.Daily.IsPositive() {
+		spent, err := Spent(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+		if spent.Add(amount).GreaterThan(limits.Daily) {
+			return fmt.Errorf("%w: %s %s spent in the last %s, %s %s more exceeds the daily limit of %s %s",
+				ErrExceeded, spent, fx.Base, Window, amount, fx.Base, limits.Daily, fx.Base)
+		}
+	}
+	return nil
+}
+
+// Set overrides the limits of a user. A zero limit falls back to the default.
+func Set(ctx context.Context, db *sql.DB, userId string, limits Limits) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO spending_limits (user_id, per_order_limit, daily_limit, updated_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET per_order_limit = EXCLUDED.per_order_limit, daily_limit = EXCLUDED.daily_limit, updated_at = EXCLUDED.updated_at`,
+		userId, nullIfZero(limits.PerOrder), nullIfZero(limits.Daily), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set spending limits: %w", err)
+	}
+	return nil
+}
+
+func nullIfZero(d decimal.Decimal) decimal.NullDecimal {
+	if d.IsZero() {
+		return decimal.NullDecimal{}
+	}
+	return decimal.NewNullDecimal(d)
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/outbox"
 	"github.com/5rfy/micro-delivery/proto/generated/payment"
 	"github.com/IBM/sarama"
+	"github.com/shopspring/decimal"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"main.go/database"
+	"main.go/fakepsp"
 	"main.go/kafka"
+	"main.go/limits"
+	"main.go/provider"
+	"main.go/risk"
 	"main.go/service"
 )
 
@@ -25,6 +35,20 @@ func main() {
 		kafkaBrokers = []string{"localhost:9092"}
 	}
 
+	// redrive <topic> re-publishes <topic>.dlq to <topic> and exits.
+	if len(os.Args) == 3 && os.Args[1] == "redrive" {
+		if err := kafka.RedriveDLQ(kafkaBrokers, os.Args[2]); err != nil {
+			log.Fatalf("Failed to re-drive DLQ: %v", err)
+		}
+		return
+	}
+
+	// fake-psp runs the bundled fake card PSP instead of the service.
+	if len(os.Args) == 2 && os.Args[1] == "fake-psp" {
+		runFakePSP()
+		return
+	}
+
 	db := database.InitDb(dsn)
 	defer db.Close()
 
@@ -38,7 +62,56 @@ func main() {
 	}
 	defer producer.Close()
 
-	go kafka.StartConsumer(db, producer, kafkaBrokers)
+	wallet := service.DefaultWalletPolicy
+	if value := os.Getenv("INITIAL_BALANCE"); value != "" {
+		wallet.InitialBalance, err = decimal.NewFromString(value)
+		if err != nil || wallet.InitialBalance.IsNegative() {
+			log.Fatalf("Invalid INITIAL_BALANCE %q: must be a non-negative amount such as 1000.00", value)
+		}
+	}
+	if value := os.Getenv("HOLD_TTL"); value != "" {
+		wallet.HoldTTL, err = time.ParseDuration(value)
+		if err != nil || wallet.HoldTTL <= 0 {
+			log.Fatalf("Invalid HOLD_TTL %q: must be a positive duration such as 24h", value)
+		}
+	}
+
+	pspURL := os.Getenv("PSP_URL")
+	if pspURL == "" {
+		pspURL = "http://localhost:8085"
+	}
+	pspTimeout := 5 * time.Second
+	if value := os.Getenv("PSP_TIMEOUT"); value != "" {
+		pspTimeout, err = time.ParseDuration(value)
+		if err != nil || pspTimeout <= 0 {
+			log.Fatalf("Invalid PSP_TIMEOUT %q: must be a positive duration such as 5s", value)
+		}
+	}
+
+	providers := provider.Registry{
+		provider.Wallet: provider.NewWallet(db, wallet.InitialBalance, wallet.HoldTTL),
+		provider.Card:   provider.NewCard(pspURL, pspTimeout),
+	}
+	spending := limits.DefaultLimits
+	if value := os.Getenv("SPENDING_PER_ORDER_LIMIT"); value != "" {
+		spending.PerOrder, err = decimal.NewFromString(value)
+		if err != nil || spending.PerOrder.IsNegative() {
+			log.Fatalf("Invalid SPENDING_PER_ORDER_LIMIT %q: must be a non-negative amount such as 10000.00", value)
+		}
+	}
+	if value := os.Getenv("SPENDING_DAILY_LIMIT"); value != "" {
+		spending.Daily, err = decimal.NewFromString(value)
+		if err != nil || spending.Daily.IsNegative() {
+			log.Fatalf("Invalid SPENDING_DAILY_LIMIT %q: must be a non-negative amount such as 25000.00", value)
+		}
+	}
+
+	server := service.NewServer(db, wallet, providers, risk.NewEngine(riskRules()), limits.NewChecker(spending))
+
+	go kafka.StartConsumer(db, server, producer, kafkaBrokers)
+	go outbox.NewRelay(db, producer).Run(context.Background())
+	go service.NewHoldExpiryWorker(db, server).Run(context.Background())
+	go service.NewAuthorizationWorker(db, server).Run(context.Background())
 
 	port := os.Getenv("GRPC_PORT")
 	if port == "" {
@@ -51,10 +124,98 @@ func main() {
 	}
 
 	grpcServer := grpc.NewServer()
-	payment.RegisterPaymentServiceServer(grpcServer, service.NewServer(db, producer))
+	payment.RegisterPaymentServiceServer(grpcServer, server)
 	reflection.Register(grpcServer)
 
 	if err := grpcServer.Serve(listen); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
+
+// riskRules reads the risk screening rules from RISK_VELOCITY_LIMIT,
+// RISK_VELOCITY_WINDOW, RISK_REVIEW_AMOUNT, RISK_DECLINE_AMOUNT,
+// RISK_NEW_USER_LIMIT, RISK_NEW_USER_AGE and RISK_BLOCKED_ADDRESSES, falling
+// back to risk.DefaultRules. A limit of 0 turns its rule off.
+func riskRules() risk.Rules {
+	rules := risk.DefaultRules
+	var err error
+	if value := os.Getenv("RISK_VELOCITY_LIMIT"); value != "" {
+		rules.VelocityLimit, err = strconv.Atoi(value)
+		if err != nil || rules.VelocityLimit < 0 {
+			log.Fatalf("Invalid RISK_VELOCITY_LIMIT %q: must be a non-negative number of payments", value)
+		}
+	}
+	if value := os.Getenv("RISK_VELOCITY_WINDOW"); value != "" {
+		rules.VelocityWindow, err = time.ParseDuration(value)
+		if err != nil || rules.VelocityWindow <= 0 {
+			log.Fatalf("Invalid RISK_VELOCITY_WINDOW %q: must be a positive duration such as 10m", value)
+		}
+	}
+	amounts := map[string]*decimal.Decimal{
+		"RISK_REVIEW_AMOUNT":  &rules.ReviewAmount,
+		"RISK_DECLINE_AMOUNT": &rules.DeclineAmount,
+		"RISK_NEW_USER_LIMIT": &rules.NewUserLimit,
+	}
+	for name, amount := range amounts {
+		if value := os.Getenv(name); value != "" {
+			*amount, err = decimal.NewFromString(value)
+			if err != nil || amount.IsNegative() {
+				log.Fatalf("Invalid %s %q: must be a non-negative amount such as 5000.00", name, value)
+			}
+		}
+	}
+	if value := os.Getenv("RISK_NEW_USER_AGE"); value != "" {
+		rules.NewUserAge, err = time.ParseDuration(value)
+		if err != nil || rules.NewUserAge < 0 {
+			log.Fatalf("Invalid RISK_NEW_USER_AGE %q: must be a duration such as 24h", value)
+		}
+	}
+	// Addresses contain commas, so the list is separated by semicolons.
+	if value := os.Getenv("RISK_BLOCKED_ADDRESSES"); value != "" {
+		for _, address := range strings.Split(value, ";") {
+			if address = strings.TrimSpace(address); address != "" {
+				rules.BlockedAddresses = append(rules.BlockedAddresses, address)
+			}
+		}
+	}
+	return rules
+}
+
+// runFakePSP serves the fake PSP configured by PSP_PORT, PSP_LATENCY,
+// PSP_DECLINE_RATE, PSP_TIMEOUT_RATE and PSP_TIMEOUT_DELAY.
+func runFakePSP() {
+	config := fakepsp.DefaultConfig
+	var err error
+	if value := os.Getenv("PSP_LATENCY"); value != "" {
+		config.Latency, err = time.ParseDuration(value)
+		if err != nil || config.Latency < 0 {
+			log.Fatalf("Invalid PSP_LATENCY %q: must be a duration such as 200ms", value)
+		}
+	}
+	if value := os.Getenv("PSP_TIMEOUT_DELAY"); value != "" {
+		config.TimeoutDelay, err = time.ParseDuration(value)
+		if err != nil || config.TimeoutDelay < 0 {
+			log.Fatalf("Invalid PSP_TIMEOUT_DELAY %q: must be a duration such as 30s", value)
+		}
+	}
+	if value := os.Getenv("PSP_DECLINE_RATE"); value != "" {
+		config.DeclineRate, err = strconv.ParseFloat(value, 64)
+		if err != nil || config.DeclineRate < 0 || config.DeclineRate > 1 {
+			log.Fatalf("Invalid PSP_DECLINE_RATE %q: must be a share between 0 and 1", value)
+		}
+	}
+	if value := os.Getenv("PSP_TIMEOUT_RATE"); value != "" {
+		config.TimeoutRate, err = strconv.ParseFloat(value, 64)
+		if err != nil || config.TimeoutRate < 0 || config.TimeoutRate > 1 {
+			log.Fatalf("Invalid PSP_TIMEOUT_RATE %q: must be a share between 0 and 1", value)
+		}
+	}
+
+	port := os.Getenv("PSP_PORT")
+	if port == "" {
+		port = "8085"
+	}
+	if err := fakepsp.ListenAndServe(":"+port, config); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
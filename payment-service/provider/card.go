@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// HeaderIdempotencyKey makes a PSP request safe to repeat: a request with a
+// key seen before returns the first response instead of being applied again.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// PSP authorization statuses on the wire.
+const (
+	PSPAuthorized = "AUTHORIZED"
+	PSPDeclined   = "DECLINED"
+	PSPCaptured   = "CAPTURED"
+	PSPVoided     = "VOIDED"
+)
+
+// AuthorizeRequest is the body of POST /v1/authorizations.
+type AuthorizeRequest struct {
+	Amount    decimal.Decimal `json:"amount"`
+	Currency  string          `json:"currency"`
+	Customer  string          `json:"customer"`
+	Reference string          `json:"reference"`
+}
+
+// RefundRequest is the body of POST /v1/authorizations/{id}/refunds.
+type RefundRequest struct {
+	Amount decimal.Decimal `json:"amount"`
+}
+
+// PSPAuthorization is the PSP's representation of an authorization.
+type PSPAuthorization struct {
+	Id          string          `json:"id"`
+	Status      string          `json:"status"`
+	Amount      decimal.Decimal `json:"amount"`
+	Currency    string          `json:"currency"`
+	Captured    decimal.Decimal `json:"captured_amount"`
+	Refunded    decimal.Decimal `json:"refunded_amount"`
+	DeclineCode string          `json:"decline_code,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+}
+
+// PSPError is the body of a PSP error response.
+type PSPError struct {
+	Error string `json:"error"`
+}
+
+// cardProvider pays by card through an external PSP over HTTP. Every
+// mutating call carries an idempotency key derived from the payment, so a
+// call repeated after a timeout or a rolled back transaction is not applied
+// twice by the PSP.
+type cardProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewCard returns the card provider talking to the PSP at baseURL. Calls
+// that get no answer within timeout fail with ErrUnavailable.
+func NewCard(baseURL string, timeout time.Duration) Provider {
+	return &cardProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *cardProvider) remote() {}
+
+func (c *cardProvider) Authorize(ctx context.Context, _ *sql.Tx, p Payment) (Authorization, error) {
+	var auth PSPAuthorization
+	err := c.do(ctx, http.MethodPost, "/v1/authorizations", "authorize:"+p.IdempotencyKey, AuthorizeRequest{
+		Amount:    p.Amount,
+		Currency:  p.Currency,
+		Customer:  p.UserId,
+		Reference: p.OrderId,
+	}, &auth)
+	if err != nil {
+		return Authorization{}, err
+	}
+	if auth.Status == PSPDeclined {
+		code := auth.DeclineCode
+		if code == "" {
+			code = CodeCardDeclined
+		}
+		return Authorization{}, Declined(code, "%s", auth.Message)
+	}
+	return Authorization{
+		Reference: auth.Id,
+		Amount:    auth.Amount,
+		Currency:  auth.Currency,
+		Rate:      decimal.NewFromInt(1),
+		ExpiresAt: auth.ExpiresAt,
+	}, nil
+}
+
+func (c *cardProvider) Capture(ctx context.Context, _ *sql.Tx, _ Payment, reference string) error {
+	return c.do(ctx, http.MethodPost, "/v1/authorizations/"+reference+"/capture", "capture:"+reference, nil, nil)
+}
+
+func (c *cardProvider) Void(ctx context.Context, _ *sql.Tx, reference string) error {
+	return c.do(ctx, http.MethodPost, "/v1/authorizations/"+reference+"/void", "void:"+reference, nil, nil)
+}
+
+func (c *cardProvider) Refund(ctx context.Context, _ *sql.Tx, _ Payment, reference string, amount decimal.Decimal, key string) error {
+	return c.do(ctx, http.MethodPost, "/v1/authorizations/"+reference+"/refunds", "refund:"+key,
+		RefundRequest{Amount: amount}, nil)
+}
+
+func (c *cardProvider) Status(ctx context.Context, reference string) (string, error) {
+	var auth PSPAuthorization
+	if err := c.do(ctx, http.MethodGet, "/v1/authorizations/"+reference, "", nil, &auth); err != nil {
+		return "", err
+	}
+	return auth.Status, nil
+}
+
+// do sends a request to the PSP and decodes a successful response into out.
+// Transport errors and 5xx responses are ErrUnavailable; 409 Conflict, which
+// the PSP answers when an authorization is no longer active, is
+// ErrNotAuthorized.
+func (c *cardProvider) do(ctx context.Context, method string, path string, key string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal PSP request: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to build PSP request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s: %v", ErrUnavailable, method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var pspErr PSPError
+		_ = json.NewDecoder(resp.Body).Decode(&pspErr)
+		err := fmt.Errorf("PSP returned %d for %s %s: %s", resp.StatusCode, method, path, pspErr.Error)
+		switch {
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		case resp.StatusCode == http.StatusConflict:
+			return fmt.Errorf("%w: %v", ErrNotAuthorized, err)
+		}
+		return err
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to decode PSP response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package provider abstracts where the money of a payment comes from: the
+// user's wallet in the ledger or an external payment service provider (PSP).
+package provider
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Provider names.
+const (
+	Wallet = "wallet"
+	Card   = "card"
+)
+
+// Decline codes.
+const (
+	CodeInsufficientFunds   = "INSUFFICIENT_FUNDS"
+	CodeCardDeclined        = "CARD_DECLINED"
+	CodeUnsupportedCurrency = "UNSUPPORTED_CURRENCY"
+	CodeUnknownProvider     = "UNKNOWN_PROVIDER"
+)
+
+// Authorization statuses reported by Status.
+const (
+	StatusAuthorized = "AUTHORIZED"
+	StatusCaptured   = "CAPTURED"
+	StatusVoided     = "VOIDED"
+)
+
+var (
+	// ErrNotAuthorized means the authorization is no longer active, e.g. it
+	// was voided or expired, so it cannot be captured.
+	ErrNotAuthorized = errors.New("authorization is not active")
+	// ErrUnavailable means the provider could not be reached or did not
+	// answer in time. The outcome is unknown and the call should be retried
+	// with the same idempotency key.
+	ErrUnavailable = errors.New("payment provider unavailable")
+)
+
+// DeclinedError is returned when a provider refuses a payment. Unlike other
+// errors it is a final answer: trying again gives the same result.
+type DeclinedError struct {
+	Code    string
+	Message string
+}
+
+func (e *DeclinedError) Error() string {
+	return fmt.Sprintf("payment declined (%s): %s", e.Code, e.Message)
+}
+
+// Declined returns a DeclinedError with a formatted message.
+func Declined(code string, format string, args ...any) error {
+	return &DeclinedError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Payment is the payment a provider is asked to move money for.
+type Payment struct {
+	Id       string
+	OrderId  string
+	UserId   string
+	Amount   decimal.Decimal
+	Currency string
+	// ChargedCurrency is the currency the provider charges in, known once
+	// the payment is authorized.
+	ChargedCurrency string
+	// IdempotencyKey identifies the payment request, so an authorization
+	// repeated for the same request is not made twice.
+	IdempotencyKey string
+}
+
+// Authorization is an amount reserved by a provider until it is captured,
+// voided, or it expires. Amount is in the currency the provider charges in,
+// converted from the payment's currency at Rate.
+type Authorization struct {
+	Reference string
+	Amount    decimal.Decimal
+	Currency  string
+	Rate      decimal.Decimal
+	ExpiresAt time.Time
+}
+
+// Provider authorizes, captures and refunds payments. Every method takes the
+// transaction the payment is stored in: the wallet posts to the ledger in it,
+// while external providers ignore it and rely on idempotency keys instead,
+// so a call repeated after the transaction rolled back is applied only once.
+// Remote providers are asked to authorize outside of any transaction, with
+// a nil tx, see IsRemote.
+type Provider interface {
+	// Authorize reserves the amount of p. A refusal is a *DeclinedError.
+	Authorize(ctx context.Context, tx *sql.Tx, p Payment) (Authorization, error)
+	// Capture takes the authorized amount of p.
+	Capture(ctx context.Context, tx *sql.Tx, p Payment, reference string) error
+	// Void releases an authorization that was not captured.
+	Void(ctx context.Context, tx *sql.Tx, reference string) error
+	// Refund returns amount, in the charged currency, of a captured payment.
+	// key identifies the refund so that it is not applied twice.
+	Refund(ctx context.Context, tx *sql.Tx, p Payment, reference string, amount decimal.Decimal, key string) error
+	// Status reports the provider's view of an authorization.
+	Status(ctx context.Context, reference string) (string, error)
+}
+
+// IsRemote reports whether p is reached over the network. A remote provider
+// may be slow to answer, so payments are authorized with it only after the
+// payment is stored and its locks are released, rather than inside the
+// transaction that stores it.
+func IsRemote(p Provider) bool {
+	_, ok := p.(interface{ remote() })
+	return ok
+}
+
+// Registry holds the configured providers by name.
+type Registry map[string]Provider
+
+// Get returns the provider called name, or a DeclinedError if there is none.
+func (r Registry) Get(name string) (Provider, error) {
+	p, ok := r[name]
+	if !ok {
+		return nil, Declined(CodeUnknownProvider, "unknown payment provider %q", name)
+	}
+	return p, nil
+}
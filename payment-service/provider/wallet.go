@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"main.go/fx"
+	"main.go/ledger"
+)
+
+// walletProvider pays from the user's wallet: an authorization is a ledger
+// hold, and capturing or refunding it posts a journal entry in tx. A payment
+// is taken from the user's wallet in its currency if the user has one, and
+// otherwise from the wallet in the base currency, converted at the current
+// exchange rate.
+type walletProvider struct {
+	db             *sql.DB
+	initialBalance decimal.Decimal
+	holdTTL        time.Duration
+}
+
+// NewWallet returns the wallet provider. The wallet in the base currency is
+// opened with initialBalance on the first payment, and holds last for holdTTL.
+func NewWallet(db *sql.DB, initialBalance decimal.Decimal, holdTTL time.Duration) Provider {
+	return &walletProvider{db: db, initialBalance: initialBalance, holdTTL: holdTTL}
+}
+
+func (w *walletProvider) Authorize(ctx context.Context, tx *sql.Tx, p Payment) (Authorization, error) {
+	currency := p.Currency
+	account := ledger.UserAccount(p.UserId, currency)
+	exists, err := ledger.AccountExists(ctx, tx, account)
+	if err != nil {
+		return Authorization{}, err
+	}
+	if !exists {
+		currency = ledger.BaseCurrency
+		account = ledger.UserAccount(p.UserId, currency)
+		if _, err := ledger.OpenUserAccount(ctx, tx, p.UserId, currency, w.initialBalance); err != nil {
+			return Authorization{}, err
+		}
+	}
+
+	amount, rate, err := fx.Convert(ctx, tx, p.Amount, p.Currency, currency)
+	if errors.Is(err, fx.ErrNoRate) {
+		return Authorization{}, Declined(CodeUnsupportedCurrency, "no exchange rate from %s to %s", p.Currency, currency)
+	}
+	if err != nil {
+		return Authorization{}, err
+	}
+
+	expiresAt := time.Now().Add(w.holdTTL)
+	holdId, err := ledger.PlaceHold(ctx, tx, account, p.Id, amount, expiresAt)
+	if errors.Is(err, ledger.ErrInsufficientFunds) {
+		available, _, err := ledger.Available(ctx, tx, account)
+		if err != nil {
+			return Authorization{}, err
+		}
+		return Authorization{}, Declined(CodeInsufficientFunds, "Insufficient funds. Available: %s %s, Required: %s %s",
+			available, currency, amount, currency)
+	}
+	if err != nil {
+		return Authorization{}, err
+	}
+	return Authorization{Reference: holdId, Amount: amount, Currency: currency, Rate: rate, ExpiresAt: expiresAt}, nil
+}
+
+func (w *walletProvider) Capture(ctx context.Context, tx *sql.Tx, p Payment, reference string) error {
+	revenue := ledger.SystemAccount(ledger.AccountRevenue, p.ChargedCurrency)
+	_, err := ledger.CaptureHold(ctx, tx, reference, revenue, ledger.EntryPayment,
+		p.Id, fmt.Sprintf("Payment for order %s", p.OrderId))
+	if errors.Is(err, ledger.ErrHoldNotActive) {
+		return fmt.Errorf("%w: hold %s", ErrNotAuthorized, reference)
+	}
+	return err
+}
+
+func (w *walletProvider) Void(ctx context.Context, tx *sql.Tx, reference string) error {
+	err := ledger.ReleaseHold(ctx, tx, reference, ledger.HoldReleased)
+	if errors.Is(err, ledger.ErrHoldNotActive) {
+		return fmt.Errorf("%w: hold %s", ErrNotAuthorized, reference)
+	}
+	return err
+}
+
+func (w *walletProvider) Refund(ctx context.Context, tx *sql.Tx, p Payment, _ string, amount decimal.Decimal, _ string) error {
+	_, err := ledger.Post(ctx, tx, ledger.EntryRefund, p.Id, fmt.Sprintf("Refund for order %s", p.OrderId),
+		ledger.Posting{AccountId: ledger.SystemAccount(ledger.AccountRevenue, p.ChargedCurrency), Amount: amount.Neg()},
+		ledger.Posting{AccountId: ledger.UserAccount(p.UserId, p.ChargedCurrency), Amount: amount},
+	)
+	return err
+}
+
+func (w *walletProvider) Status(ctx context.Context, reference string) (string, error) {
+	var status string
+	err := w.db.QueryRowContext(ctx, `SELECT status FROM holds WHERE id = $1`, reference).Scan(&status)
+	if err != nil {
+		return "", fmt.Errorf("failed to get hold: %w", err)
+	}
+	switch status {
+	case ledger.HoldActive:
+		return StatusAuthorized, nil
+	case ledger.HoldCaptured:
+		return StatusCaptured, nil
+	}
+	return StatusVoided, nil
+}
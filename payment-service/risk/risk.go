@@ -0,0 +1,154 @@
+// Package risk screens payments before any money is reserved for them. Each
+// rule that matches adds to the payment's score, and the score decides
+// whether the payment goes ahead, waits for a manual review or is declined.
+package risk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"main.go/fx"
+)
+
+// Decisions.
+const (
+	Approve = "APPROVE"
+	Review  = "REVIEW"
+	Decline = "DECLINE"
+)
+
+// Score thresholds: a payment scoring ReviewScore or more is held for review,
+// and one scoring DeclineScore or more is declined outright.
+const (
+	ReviewScore  = 50
+	DeclineScore = 100
+)
+
+// Rules configures the screening. A rule whose limit is zero is off.
+type Rules struct {
+	// VelocityLimit is how many payments a user may make within
+	// VelocityWindow before further ones are reviewed.
+	VelocityLimit  int
+	VelocityWindow time.Duration
+	// Payments of ReviewAmount or more are reviewed, and payments of
+	// DeclineAmount or more are declined. Amounts are in fx.Base.
+	ReviewAmount  decimal.Decimal
+	DeclineAmount decimal.Decimal
+	// NewUserLimit is the largest amount, in fx.Base, paid without review by
+	// a user whose first captured payment is younger than NewUserAge.
+	NewUserLimit decimal.Decimal
+	NewUserAge   time.Duration
+	// BlockedAddresses declines payments for orders delivered to an address
+	// containing one of them, ignoring case.
+	BlockedAddresses []string
+}
+
+var DefaultRules = Rules{
+	VelocityLimit:  5,
+	VelocityWindow: 10 * time.Minute,
+	ReviewAmount:   decimal.NewFromInt(5000),
+	DeclineAmount:  decimal.NewFromInt(20000),
+	NewUserLimit:   decimal.NewFromInt(1000),
+	NewUserAge:     24 * time.Hour,
+}
+
+// Payment is the payment being screened.
+type Payment struct {
+	UserId          string
+	Amount          decimal.Decimal
+	Currency        string
+	DeliveryAddress string
+}
+
+// Assessment is the outcome of screening a payment.
+type Assessment struct {
+	Score    int
+	Decision string
+	// Reasons names the rules that matched.
+	Reasons []string
+}
+
+type Engine struct {
+	rules Rules
+}
+
+func NewEngine(rules Rules) *Engine {
+	return &Engine{rules: rules}
+}
+
+// Assess screens p against the rules, reading the user's earlier payments
+// in tx.
+func (e *Engine) Assess(ctx context.Context, tx *sql.Tx, p Payment) (Assessment, error) {
+	var a Assessment
+	add := func(score int, format string, args ...any) {
+		a.Score += score
+		a.Reasons = append(a.Reasons, fmt.Sprintf(format, args...))
+	}
+
+	// Amounts without an exchange rate are compared as they are; the
+	// provider declines them anyway.
+	amount, _, err := fx.Convert(ctx, tx, p.Amount, p.Currency, fx.Base)
+	if errors.Is(err, fx.ErrNoRate) {
+		amount = p.Amount
+	} else if err != nil {
+		return Assessment{}, err
+	}
+
+	if e.rules.VelocityLimit > 0 {
+		var recent int
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM payments WHERE user_id = $1 AND created_at > $2`,
+			p.UserId, time.Now().Add(-e.rules.VelocityWindow),
+		).Scan(&recent)
+		if err != nil {
+			return Assessment{}, fmt.Errorf("failed to count recent payments: %w", err)
+		}
+		if recent >= e.rules.VelocityLimit {
+			add(ReviewScore, "velocity: %d payments within %s", recent, e.rules.VelocityWindow)
+		}
+	}
+
+	switch {
+	case e.rules.DeclineAmount.IsPositive() && amount.GreaterThanOrEqual(e.rules.DeclineAmount):
+		add(DeclineScore, "amount: %s %s is at least %s %s", amount, fx.Base, e.rules.DeclineAmount, fx.Base)
+	case e.rules.ReviewAmount.IsPositive() && amount.GreaterThanOrEqual(e.rules.ReviewAmount):
+		add(ReviewScore, "amount: %s %s is at least %s %s", amount, fx.Base, e.rules.ReviewAmount, fx.Base)
+	}
+
+	if e.rules.NewUserLimit.IsPositive() && amount.GreaterThan(e.rules.NewUserLimit) {
+		var first sql.NullTime
+		err := tx.QueryRowContext(ctx,
+			`SELECT MIN(captured_at) FROM payments WHERE user_id = $1`,
+			p.UserId,
+		).Scan(&first)
+		if err != nil {
+			return Assessment{}, fmt.Errorf("failed to get first payment: %w", err)
+		}
+		if !first.Valid || time.Since(first.Time) < e.rules.NewUserAge {
+			add(ReviewScore, "new user: %s %s exceeds the new user limit of %s %s", amount, fx.Base, e.rules.NewUserLimit, fx.Base)
+		}
+	}
+
+	address := strings.ToLower(p.DeliveryAddress)
+	for _, blocked := range e.rules.BlockedAddresses {
+		if blocked != "" && strings.Contains(address, strings.ToLower(blocked)) {
+			add(DeclineScore, "address: delivery address is blocked")
+			break
+		}
+	}
+
+	switch {
+	case a.Score >= DeclineScore:
+		a.Decision = Decline
+	case a.Score >= ReviewScore:
+		a.Decision = Review
+	default:
+		a.Decision = Approve
+	}
+	return a, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/shopspring/decimal"
+	"google.golang.org/protobuf/encoding/protojson"
+	"main.go/provider"
+	"main.go/structs"
+)
+
+const (
+	authorizationRetryInterval  = 30 * time.Second
+	authorizationRetryBatchSize = 50
+	// authorizationGrace is how long the caller that stored an AUTHORIZING
+	// payment has to settle it before the AuthorizationWorker takes over.
+	authorizationGrace = time.Minute
+)
+
+// pendingAuthorization is an AUTHORIZING payment as the remote provider is
+// asked to authorize it.
+type pendingAuthorization struct {
+	id           string
+	orderId      string
+	userId       string
+	amount       decimal.Decimal
+	currency     string
+	status       string
+	provider     string
+	key          string
+	riskScore    int32
+	riskDecision string
+}
+
+// SettleAuthorization authorizes an AUTHORIZING payment with its remote
+// provider and stores the outcome. The provider is called outside of any
+// transaction, so a slow provider holds neither the user's locks nor a
+// database connection, and under the payment's authorization key, so a call
+// repeated after a timeout or a crash gets the first authorization back
+// instead of a second one. The outcome is then stored, published on
+// payment.completed and recorded as the response to the payment request in
+// one transaction. A payment that was settled or cancelled in the meantime
+// is left as it is, and an authorization it no longer needs is voided. A
+// provider that cannot be reached fails the call with
+// provider.ErrUnavailable and leaves the payment AUTHORIZING, to be settled
+// again by the caller or the AuthorizationWorker.
+func (s *Server) SettleAuthorization(ctx context.Context, paymentId string) (*payment.ProcessPaymentResponse, error) {
+	var p pendingAuthorization
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, order_id, user_id, amount, currency, status, provider, COALESCE(authorization_key, id::text),
+		        COALESCE(risk_score, 0), COALESCE(risk_decision, '')
+		 FROM payments WHERE id = $1`,
+		paymentId,
+	).Scan(&p.id, &p.orderId, &p.userId, &p.amount, &p.currency, &p.status, &p.provider, &p.key,
+		&p.riskScore, &p.riskDecision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", errPaymentNotFound, paymentId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	if p.status != StatusAuthorizing {
+		return s.settledResponse(ctx, &p)
+	}
+
+	prov, err := s.providers.Get(p.provider)
+	if err != nil {
+		return nil, err
+	}
+	request := provider.Payment{
+		Id:             p.id,
+		OrderId:        p.orderId,
+		UserId:         p.userId,
+		Amount:         p.amount,
+		Currency:       p.currency,
+		IdempotencyKey: p.key,
+	}
+	auth, err := prov.Authorize(ctx, nil, request)
+	result, err := authorizationOf(p.provider, request, auth, err)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	locked, err := lockPayment(ctx, tx, `WHERE id = $1`, p.id)
+	if err != nil {
+		return nil, err
+	}
+	if locked.status != StatusAuthorizing {
+		tx.Rollback()
+		// Another caller settled the payment with this same authorization,
+		// or the order was cancelled while the provider was asked.
+		if result.reference.Valid && locked.reference.String != result.reference.String {
+			if err := prov.Void(ctx, nil, result.reference.String); err != nil && !errors.Is(err, provider.ErrNotAuthorized) {
+				log.Printf("Failed to void unneeded authorization %s of payment %s: %v", result.reference.String, p.id, err)
+			}
+		}
+		p.status = locked.status
+		return s.settledResponse(ctx, &p)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payments SET status = $1, provider_ref = $2, charged_amount = $3, charged_currency = $4, fx_rate = $5,
+		                     expires_at = $6
+		 WHERE id = $7`,
+		result.status, result.reference, result.chargedAmount, result.chargedCurrency, result.rate,
+		result.expiresAt, p.id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update payment: %w", err)
+	}
+	err = enqueueCompleted(ctx, tx, structs.PaymentCompletedEvent{
+		OrderId:   p.orderId,
+		PaymentId: p.id,
+		UserId:    p.userId,
+		Amount:    p.amount,
+		Status:    result.status,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &payment.ProcessPaymentResponse{
+		PaymentId:    p.id,
+		Status:       result.status,
+		Message:      result.message,
+		RiskScore:    p.riskScore,
+		RiskDecision: p.riskDecision,
+	}
+	if err := updateResponse(ctx, tx, p.key, resp); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Payment %s for order %s authorized by %s: %s", p.id, p.orderId, p.provider, result.status)
+	return resp, nil
+}
+
+// settledResponse returns the response recorded for a payment that is no
+// longer AUTHORIZING, or one made up from its status if none was recorded.
+func (s *Server) settledResponse(ctx context.Context, p *pendingAuthorization) (*payment.ProcessPaymentResponse, error) {
+	var body string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT response FROM payment_idempotency_keys WHERE key = $1`,
+		p.key,
+	).Scan(&body)
+	if err == nil {
+		var resp payment.ProcessPaymentResponse
+		if err := protojson.Unmarshal([]byte(body), &resp); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal recorded response: %w", err)
+		}
+		if resp.PaymentId == p.id && resp.Status != StatusAuthorizing {
+			return &resp, nil
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	return &payment.ProcessPaymentResponse{
+		PaymentId:    p.id,
+		Status:       p.status,
+		Message:      fmt.Sprintf("Payment %s of order %s is %s", p.id, p.orderId, p.status),
+		RiskScore:    p.riskScore,
+		RiskDecision: p.riskDecision,
+	}, nil
+}
+
+// AuthorizationWorker settles the AUTHORIZING payments their caller did not,
+// because the provider could not be reached or the caller stopped before it
+// got the answer. Settling is safe to repeat, so the worker can run on every
+// replica.
+type AuthorizationWorker struct {
+	db     *sql.DB
+	server *Server
+}
+
+func NewAuthorizationWorker(db *sql.DB, server *Server) *AuthorizationWorker {
+	return &AuthorizationWorker{db: db, server: server}
+}
+
+func (w *AuthorizationWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(authorizationRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.settlePending(ctx); err != nil {
+				log.Printf("Authorization retry error: %v", err)
+			}
+		}
+	}
+}
+
+func (w *AuthorizationWorker) settlePending(ctx context.Context) error {
+	rows, err := w.db.QueryContext(ctx,
+		`SELECT id FROM payments WHERE status = $1 AND created_at <= $2 ORDER BY created_at LIMIT $3`,
+		StatusAuthorizing, time.Now().Add(-authorizationGrace), authorizationRetryBatchSize,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to select pending authorizations: %w", err)
+	}
+	var pending []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan payment: %w", err)
+		}
+		pending = append(pending, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to select pending authorizations: %w", err)
+	}
+
+	// A payment the provider cannot answer for yet does not hold back the
+	// others; it is tried again on the next tick.
+	for _, id := range pending {
+		if _, err := w.server.SettleAuthorization(ctx, id); err != nil {
+			log.Printf("Failed to settle authorization of payment %s: %v", id, err)
+		}
+	}
+	return nil
+}
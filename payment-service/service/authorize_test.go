@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"main.go/fakepsp"
+	"main.go/limits"
+	"main.go/provider"
+)
+
+func TestCardPaymentIsAuthorizedOutsideThePaymentTransaction(t *testing.T) {
+	s, db := newServer(t, DefaultWalletPolicy.InitialBalance, limits.Limits{})
+	ctx := context.Background()
+	orderId := uuid.New().String()
+
+	// While the PSP is asked, the payment is already committed and the user
+	// can pay for another order.
+	var seen []string
+	psp := fakepsp.NewServer(fakepsp.DefaultConfig).Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/authorizations" {
+			var status string
+			if err := db.QueryRow(`SELECT status FROM payments WHERE order_id = $1`, orderId).Scan(&status); err != nil {
+				t.Errorf("failed to get payment during authorization: %v", err)
+			}
+			seen = append(seen, status)
+
+			other, err := s.ProcessPayment(ctx, &payment.ProcessPaymentRequest{
+				OrderId: uuid.New().String(), UserId: "user-1", Amount: 5, Currency: "USD",
+			})
+			if err != nil || other.Status != StatusAuthorized {
+				t.Errorf("wallet payment during authorization = %v, %v", other, err)
+			}
+		}
+		psp.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	s.providers[provider.Card] = provider.NewCard(server.URL, 5*time.Second)
+
+	req := &payment.ProcessPaymentRequest{
+		OrderId: orderId, UserId: "user-1", Amount: 10, Currency: "USD", Provider: provider.Card,
+	}
+	resp, err := s.ProcessPayment(ctx, req)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if resp.Status != StatusAuthorized {
+		t.Fatalf("status = %s, want %s", resp.Status, StatusAuthorized)
+	}
+	if len(seen) != 1 || seen[0] != StatusAuthorizing {
+		t.Fatalf("payment statuses seen by the PSP = %v, want [%s]", seen, StatusAuthorizing)
+	}
+
+	var events int
+	err = db.QueryRow(`SELECT COUNT(*) FROM outbox_events WHERE topic = $1 AND event_key = $2`,
+		TopicPaymentCompleted, orderId).Scan(&events)
+	if err != nil {
+		t.Fatalf("failed to count events: %v", err)
+	}
+	if events != 1 {
+		t.Fatalf("payment.completed events = %d, want 1", events)
+	}
+
+	// A repeated request gets the settled answer without asking the PSP.
+	again, err := s.ProcessPayment(ctx, req)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if again.PaymentId != resp.PaymentId || again.Status != StatusAuthorized {
+		t.Fatalf("repeated request = %s %s, want %s %s", again.PaymentId, again.Status, resp.PaymentId, StatusAuthorized)
+	}
+	if len(seen) != 1 {
+		t.Fatalf("PSP asked %d times, want 1", len(seen))
+	}
+
+	spending, err := s.GetSpendingLimits(ctx, &payment.GetSpendingLimitsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("GetSpendingLimits: %v", err)
+	}
+	if want := decimal.NewFromInt(15); !decimal.NewFromFloat(spending.Spent).Equal(want) {
+		t.Fatalf("spent = %v, want %s", spending.Spent, want)
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/outbox"
+	"main.go/provider"
+	"main.go/structs"
+)
+
+const (
+	holdExpiryInterval  = time.Minute
+	holdExpiryBatchSize = 50
+)
+
+// CapturePaymentTx captures the authorized payment of an order inside tx
+// with the provider that authorized it; for the wallet this moves the held
+// amount from the user's wallet to revenue. A payment.captured reply is
+// always sent: CAPTURED if the money was taken, FAILED if there was no active
+// authorization left to capture.
+func (s *Server) CapturePaymentTx(ctx context.Context, tx *sql.Tx, orderId string) error {
+	event := structs.PaymentCapturedEvent{OrderId: orderId, Status: "FAILED"}
+
+	p, err := lockPayment(ctx, tx,
+		`WHERE order_id = $1 AND status IN ('AUTHORIZED', 'SUCCESS', 'PARTIALLY_REFUNDED', 'REFUNDED', 'RELEASED', 'EXPIRED')`, orderId)
+	if p != nil {
+		event.PaymentId, event.UserId, event.Amount = p.id, p.userId, p.amount
+	}
+	switch {
+	case errors.Is(err, errPaymentNotFound):
+		event.Reason = "no authorized payment"
+	case err != nil:
+		return err
+	case p.status == StatusAuthorized:
+		prov, err := s.providers.Get(p.provider)
+		if err != nil {
+			return err
+		}
+		err = prov.Capture(ctx, tx, p.payment(), p.reference.String)
+		if errors.Is(err, provider.ErrNotAuthorized) {
+			event.Reason = err.Error()
+			break
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE payments SET status = $1, captured_at = $2 WHERE id = $3`,
+			StatusSuccess, time.Now(), p.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+		event.Status = "CAPTURED"
+	case p.status == StatusReleased || p.status == StatusExpired:
+		event.Reason = "authorization " + p.status
+	default:
+		// Captured before; the reply is sent again for a re-sent command.
+		event.Status = "CAPTURED"
+	}
+
+	if event.Status == "FAILED" {
+		log.Printf("Cannot capture payment for order %s: %s", orderId, event.Reason)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return outbox.Enqueue(ctx, tx, TopicPaymentCaptured, orderId, payload)
+}
+
+// releaseAuthorizedTx voids the authorization of a payment locked by the
+// caller, ending the payment with status RELEASED or EXPIRED. An
+// authorization the provider already dropped, e.g. because it expired there
+// first, counts as voided.
+func (s *Server) releaseAuthorizedTx(ctx context.Context, tx *sql.Tx, p *refundablePayment, status string) error {
+	prov, err := s.providers.Get(p.provider)
+	if err != nil {
+		return err
+	}
+	err = prov.Void(ctx, tx, p.reference.String)
+	if errors.Is(err, provider.ErrNotAuthorized) {
+		log.Printf("Authorization of payment %s is already inactive: %v", p.id, err)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payments SET status = $1 WHERE id = $2`,
+		status, p.id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	p.status = status
+	return nil
+}
+
+// HoldExpiryWorker voids the authorizations of payments that were not
+// captured in time. Payments are claimed with FOR UPDATE SKIP LOCKED, so the
+// worker can run on every replica.
+type HoldExpiryWorker struct {
+	db     *sql.DB
+	server *Server
+}
+
+func NewHoldExpiryWorker(db *sql.DB, server *Server) *HoldExpiryWorker {
+	return &HoldExpiryWorker{db: db, server: server}
+}
+
+func (w *HoldExpiryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(holdExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.expireHolds(ctx); err != nil {
+				log.Printf("Hold expiry error: %v", err)
+			}
+		}
+	}
+}
+
+func (w *HoldExpiryWorker) expireHolds(ctx context.Context) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+paymentColumns+` FROM payments
+		 WHERE status = $1 AND expires_at <= $2
+		 ORDER BY expires_at
+		 LIMIT $3
+		 FOR UPDATE SKIP LOCKED`,
+		StatusAuthorized, time.Now(), holdExpiryBatchSize,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to select expired holds: %w", err)
+	}
+
+	var payments []*refundablePayment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		payments = append(payments, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read expired holds: %w", err)
+	}
+
+	for _, p := range payments {
+		if err := w.server.releaseAuthorizedTx(ctx, tx, p, StatusExpired); err != nil {
+			return fmt.Errorf("failed to release hold of payment %s: %w", p.id, err)
+		}
+		log.Printf("Hold of payment %s for order %s expired", p.id, p.orderId)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
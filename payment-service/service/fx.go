@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/fx"
+)
+
+func (s *Server) ListFxRates(ctx context.Context, _ *payment.ListFxRatesRequest) (*payment.ListFxRatesResponse, error) {
+	rates, err := fx.List(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &payment.ListFxRatesResponse{}
+	for _, r := range rates {
+		response.Rates = append(response.Rates, fxRateToProto(r))
+	}
+	return response, nil
+}
+
+// SetFxRate creates or replaces the exchange rate of a currency pair.
+func (s *Server) SetFxRate(ctx context.Context, req *payment.SetFxRateRequest) (*payment.FxRate, error) {
+	base, err := walletCurrency(req.Base)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := walletCurrency(req.Quote)
+	if err != nil {
+		return nil, err
+	}
+	if req.Base == "" || req.Quote == "" || base == quote {
+		return nil, status.Errorf(codes.InvalidArgument, "base and quote must be two different currencies")
+	}
+	rate := decimal.NewFromFloat(req.Rate)
+	if !rate.IsPositive() {
+		return nil, status.Errorf(codes.InvalidArgument, "rate must be positive")
+	}
+
+	r, err := fx.SetRate(ctx, s.db, base, quote, rate)
+	if err != nil {
+		return nil, err
+	}
+	return fxRateToProto(r), nil
+}
+
+// Convert quotes an amount in another currency at the current rate.
+func (s *Server) Convert(ctx context.Context, req *payment.ConvertRequest) (*payment.ConvertResponse, error) {
+	from, err := walletCurrency(req.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := walletCurrency(req.To)
+	if err != nil {
+		return nil, err
+	}
+	amount := decimal.NewFromFloat(req.Amount)
+	if amount.IsNegative() {
+		return nil, status.Errorf(codes.InvalidArgument, "amount must not be negative")
+	}
+
+	converted, rate, err := fx.Convert(ctx, s.db, amount, from, to)
+	if errors.Is(err, fx.ErrNoRate) {
+		return nil, status.Errorf(codes.NotFound, "no exchange rate from %s to %s", from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment.ConvertResponse{
+		Amount: converted.InexactFloat64(),
+		Rate:   rate.InexactFloat64(),
+	}, nil
+}
+
+func fxRateToProto(r fx.Rate) *payment.FxRate {
+	return &payment.FxRate{
+		Base:      r.Base,
+		Quote:     r.Quote,
+		Rate:      r.Rate.InexactFloat64(),
+		UpdatedAt: r.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/shopspring/decimal"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var errIdempotencyKeyReused = errors.New("idempotency key was used for a different payment request")
+
+// requestFingerprint identifies what a payment request asks for, so a reused
+// idempotency key can be told apart from a repeated request.
+func requestFingerprint(req *payment.ProcessPaymentRequest, providerName string, currency string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s",
+		req.OrderId, req.UserId, decimal.NewFromFloat(req.Amount).StringFixed(2), currency, providerName)))
+	return hex.EncodeToString(sum[:])
+}
+
+// replayResponse returns the response recorded for key, or nil if the key is
+// new. A key recorded for a different request fails with
+// errIdempotencyKeyReused.
+func replayResponse(ctx context.Context, tx *sql.Tx, key string, fingerprint string) (*payment.ProcessPaymentResponse, error) {
+	var recorded, body string
+	err := tx.QueryRowContext(ctx,
+		`SELECT fingerprint, response FROM payment_idempotency_keys WHERE key = $1`,
+		key,
+	).Scan(&recorded, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if recorded != fingerprint {
+		return nil, fmt.Errorf("%w: %s", errIdempotencyKeyReused, key)
+	}
+
+	var resp payment.ProcessPaymentResponse
+	if err := protojson.Unmarshal([]byte(body), &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal recorded response: %w", err)
+	}
+	return &resp, nil
+}
+
+// saveResponse records the response to the request identified by key.
+func saveResponse(ctx context.Context, tx *sql.Tx, key string, orderId string, fingerprint string, resp *payment.ProcessPaymentResponse) error {
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO payment_idempotency_keys (key, order_id, fingerprint, response, created_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		key, orderId, fingerprint, string(body), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency key: %w", err)
+	}
+	return nil
+}
+
+// updateResponse replaces the response recorded for key, once a payment
+// recorded while it was being authorized is settled. A key that was never
+// recorded, like the one of a payment approved in review, is left alone.
+func updateResponse(ctx context.Context, tx *sql.Tx, key string, resp *payment.ProcessPaymentResponse) error {
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payment_idempotency_keys SET response = $1 WHERE key = $2`,
+		string(body), key,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update idempotency key: %w", err)
+	}
+	return nil
+}
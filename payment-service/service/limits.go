@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/fx"
+	"main.go/limits"
+)
+
+func (s *Server) GetSpendingLimits(ctx context.Context, req *payment.GetSpendingLimitsRequest) (*payment.SpendingLimits, error) {
+	if req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	return s.spendingLimits(ctx, req.UserId)
+}
+
+// SetSpendingLimits overrides the limits of a user; a zero limit falls back
+// to the default.
+func (s *Server) SetSpendingLimits(ctx context.Context, req *payment.SetSpendingLimitsRequest) (*payment.SpendingLimits, error) {
+	if req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	perOrder := decimal.NewFromFloat(req.PerOrderLimit)
+	daily := decimal.NewFromFloat(req.DailyLimit)
+	if perOrder.IsNegative() || daily.IsNegative() {
+		return nil, status.Errorf(codes.InvalidArgument, "limits must not be negative")
+	}
+
+	if err := limits.Set(ctx, s.db, req.UserId, limits.Limits{PerOrder: perOrder, Daily: daily}); err != nil {
+		return nil, err
+	}
+	return s.spendingLimits(ctx, req.UserId)
+}
+
+func (s *Server) spendingLimits(ctx context.Context, userId string) (*payment.SpendingLimits, error) {
+	effective, overridden, err := s.limits.Effective(ctx, s.db, userId)
+	if err != nil {
+		return nil, err
+	}
+	spent, err := limits.Spent(ctx, s.db, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &payment.SpendingLimits{
+		UserId:        userId,
+		PerOrderLimit: effective.PerOrder.InexactFloat64(),
+		DailyLimit:    effective.Daily.InexactFloat64(),
+		Spent:         spent.InexactFloat64(),
+		Currency:      fx.Base,
+		Overridden:    overridden,
+	}
+	if effective.Daily.IsPositive() {
+		response.Remaining = decimal.Max(effective.Daily.Sub(spent), decimal.Zero).InexactFloat64()
+	}
+	return response, nil
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/outbox"
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/provider"
+	"main.go/structs"
+)
+
+var (
+	errPaymentNotFound = errors.New("payment not found")
+	errNotRefundable   = errors.New("payment is not refundable")
+	errRefundTooLarge  = errors.New("refund exceeds the amount not refunded yet")
+)
+
+// refundablePayment is a payment row locked for a refund.
+type refundablePayment struct {
+	id        string
+	orderId   string
+	userId    string
+	amount    decimal.Decimal
+	currency  string
+	refunded  decimal.Decimal
+	status    string
+	provider  string
+	reference sql.NullString
+	// charged is amount in chargedCurrency, converted at fxRate.
+	charged         decimal.Decimal
+	chargedCurrency string
+	fxRate          decimal.Decimal
+}
+
+func (p *refundablePayment) remaining() decimal.Decimal {
+	return p.amount.Sub(p.refunded)
+}
+
+// chargedFor converts an amount refunded in total to the charged currency.
+// Refunds are charged as the difference between the converted totals before
+// and after them, so that rounding never adds up to more than was charged.
+func (p *refundablePayment) chargedFor(total decimal.Decimal) decimal.Decimal {
+	if total.Equal(p.amount) {
+		return p.charged
+	}
+	return total.Mul(p.fxRate).Round(2)
+}
+
+// payment describes p to its provider.
+func (p *refundablePayment) payment() provider.Payment {
+	return provider.Payment{
+		Id:              p.id,
+		OrderId:         p.orderId,
+		UserId:          p.userId,
+		Amount:          p.amount,
+		Currency:        p.currency,
+		ChargedCurrency: p.chargedCurrency,
+	}
+}
+
+// RefundPayment refunds a successful payment in full or in part. Several
+// partial refunds may follow each other until the whole amount is refunded.
+func (s *Server) RefundPayment(ctx context.Context, req *payment.RefundPaymentRequest) (*payment.RefundPaymentResponse, error) {
+	amount := decimal.NewFromFloat(req.Amount)
+	if amount.IsNegative() || !amount.Equal(amount.Round(2)) {
+		return nil, status.Errorf(codes.InvalidArgument, "amount must be positive with at most two decimal places, or 0 for a full refund")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := lockPayment(ctx, tx, `WHERE id = $1`, req.PaymentId)
+	if errors.Is(err, errPaymentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "payment %s not found", req.PaymentId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if amount.IsZero() {
+		amount = p.remaining()
+	}
+	refundId, err := s.refundTx(ctx, tx, p, amount, req.Reason)
+	switch {
+	case errors.Is(err, errNotRefundable):
+		return nil, status.Errorf(codes.FailedPrecondition, "payment %s is %s and cannot be refunded", p.id, p.status)
+	case errors.Is(err, errRefundTooLarge):
+		return nil, status.Errorf(codes.FailedPrecondition, "refund of %s exceeds the %s not refunded yet", amount, p.remaining())
+	case errors.Is(err, provider.ErrUnavailable):
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	case err != nil:
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &payment.RefundPaymentResponse{
+		RefundId:        refundId,
+		PaymentId:       p.id,
+		Status:          p.status,
+		Amount:          amount.InexactFloat64(),
+		RefundedAmount:  p.refunded.InexactFloat64(),
+		RemainingAmount: p.remaining().InexactFloat64(),
+	}, nil
+}
+
+// RefundOrderTx compensates a cancelled order inside tx: the authorization of
+// an authorized payment is voided, whatever is left of a captured payment is
+// refunded through its provider, a payment held for review is declined, a
+// payment still being authorized is released, and
+// the order is remembered so a late payment command does not charge it
+// again. A payment.refunded reply is always sent, with status RELEASED for a
+// released hold and NOT_CHARGED when there was nothing to refund.
+func (s *Server) RefundOrderTx(ctx context.Context, tx *sql.Tx, orderId string, reason string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO cancelled_orders (order_id, reason, cancelled_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (order_id) DO NOTHING`,
+		orderId, reason, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record cancellation: %w", err)
+	}
+
+	// Nothing was reserved for a payment waiting for review.
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payments SET status = $1, review_note = $2, reviewed_at = $3 WHERE order_id = $4 AND status = $5`,
+		StatusDeclined, "order cancelled during review", time.Now(), orderId, StatusReview,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to decline payment under review: %w", err)
+	}
+	// The authorization of a payment still waiting for its provider is
+	// voided when the answer comes, see SettleAuthorization.
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payments SET status = $1 WHERE order_id = $2 AND status = $3`,
+		StatusReleased, orderId, StatusAuthorizing,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release payment being authorized: %w", err)
+	}
+
+	p, err := lockPayment(ctx, tx,
+		`WHERE order_id = $1 AND status IN ('AUTHORIZED', 'SUCCESS', 'PARTIALLY_REFUNDED', 'REFUNDED')`, orderId)
+	if errors.Is(err, errPaymentNotFound) {
+		return enqueueRefunded(ctx, tx, structs.PaymentRefundedEvent{
+			OrderId:       orderId,
+			Amount:        decimal.Zero,
+			RefundedTotal: decimal.Zero,
+			Reason:        reason,
+			Status:        "NOT_CHARGED",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.status == StatusAuthorized {
+		if err := s.releaseAuthorizedTx(ctx, tx, p, StatusReleased); err != nil {
+			return err
+		}
+		return enqueueRefunded(ctx, tx, structs.PaymentRefundedEvent{
+			OrderId:       orderId,
+			PaymentId:     p.id,
+			UserId:        p.userId,
+			Amount:        decimal.Zero,
+			RefundedTotal: decimal.Zero,
+			Reason:        reason,
+			Status:        StatusReleased,
+		})
+	}
+
+	// A payment refunded by hand before the cancellation has nothing left.
+	if p.status == StatusRefunded {
+		return enqueueRefunded(ctx, tx, structs.PaymentRefundedEvent{
+			OrderId:       orderId,
+			PaymentId:     p.id,
+			UserId:        p.userId,
+			Amount:        decimal.Zero,
+			RefundedTotal: p.refunded,
+			Reason:        reason,
+			Status:        StatusRefunded,
+		})
+	}
+
+	_, err = s.refundTx(ctx, tx, p, p.remaining(), reason)
+	return err
+}
+
+const paymentColumns = `id, order_id, user_id, amount, currency, refunded_amount, status, provider, provider_ref,
+	COALESCE(charged_amount, amount), COALESCE(charged_currency, currency), COALESCE(fx_rate, 1)`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPayment(row scanner) (*refundablePayment, error) {
+	var p refundablePayment
+	err := row.Scan(&p.id, &p.orderId, &p.userId, &p.amount, &p.currency, &p.refunded, &p.status, &p.provider, &p.reference,
+		&p.charged, &p.chargedCurrency, &p.fxRate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errPaymentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	return &p, nil
+}
+
+func lockPayment(ctx context.Context, tx *sql.Tx, where string, arg string) (*refundablePayment, error) {
+	return scanPayment(tx.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments `+where+` FOR UPDATE`, arg))
+}
+
+// refundTx returns amount of a locked payment to the user through its
+// provider inside tx, records the refund and publishes payment.refunded. It
+// updates p to the payment's state after the refund.
+func (s *Server) refundTx(ctx context.Context, tx *sql.Tx, p *refundablePayment, amount decimal.Decimal, reason string) (string, error) {
+	if p.status != StatusSuccess && p.status != StatusPartiallyRefunded {
+		return "", errNotRefundable
+	}
+	if !amount.IsPositive() || amount.GreaterThan(p.remaining()) {
+		return "", errRefundTooLarge
+	}
+
+	prov, err := s.providers.Get(p.provider)
+	if err != nil {
+		return "", err
+	}
+	// The refunded total after this refund identifies it: a retry of the
+	// same refund repeats it, while the next refund always raises it.
+	total := p.refunded.Add(amount)
+	key := fmt.Sprintf("%s:%s", p.id, total.StringFixed(2))
+	charged := p.chargedFor(total).Sub(p.chargedFor(p.refunded))
+	if charged.IsPositive() {
+		if err := prov.Refund(ctx, tx, p.payment(), p.reference.String, charged, key); err != nil {
+			return "", err
+		}
+	}
+
+	refundId := uuid.New().String()
+	p.refunded = p.refunded.Add(amount)
+	p.status = StatusPartiallyRefunded
+	if p.remaining().IsZero() {
+		p.status = StatusRefunded
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payments SET refunded_amount = $1, status = $2 WHERE id = $3`,
+		p.refunded, p.status, p.id,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to update payment: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO refunds (id, payment_id, order_id, amount, reason, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		refundId, p.id, p.orderId, amount, reason, time.Now(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert refund: %w", err)
+	}
+
+	err = enqueueRefunded(ctx, tx, structs.PaymentRefundedEvent{
+		OrderId:       p.orderId,
+		PaymentId:     p.id,
+		RefundId:      refundId,
+		UserId:        p.userId,
+		Amount:        amount,
+		RefundedTotal: p.refunded,
+		Reason:        reason,
+		Status:        p.status,
+	})
+	if err != nil {
+		return "", err
+	}
+	return refundId, nil
+}
+
+func enqueueRefunded(ctx context.Context, tx *sql.Tx, event structs.PaymentRefundedEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return outbox.Enqueue(ctx, tx, TopicPaymentRefunded, event.OrderId, payload)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/provider"
+	"main.go/structs"
+)
+
+// Review decisions.
+const (
+	reviewApprove = "APPROVE"
+	reviewReject  = "REJECT"
+)
+
+// ListPaymentsForReview returns the payments held for review, oldest first.
+func (s *Server) ListPaymentsForReview(ctx context.Context, req *payment.ListPaymentsForReviewRequest) (*payment.ListPaymentsForReviewResponse, error) {
+	pageSize, _, err := parsePage(req.PageSize, "")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, order_id, user_id, amount, currency, provider, COALESCE(risk_score, 0), COALESCE(risk_reasons, '[]'), created_at
+		 FROM payments
+		 WHERE status = $1
+		 ORDER BY created_at
+		 LIMIT $2`,
+		StatusReview, pageSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments for review: %w", err)
+	}
+	defer rows.Close()
+
+	response := &payment.ListPaymentsForReviewResponse{}
+	for rows.Next() {
+		var p payment.PaymentForReview
+		var reasons string
+		var createdAt time.Time
+		if err := rows.Scan(&p.PaymentId, &p.OrderId, &p.UserId, &p.Amount, &p.Currency, &p.Provider,
+			&p.RiskScore, &reasons, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		if err := json.Unmarshal([]byte(reasons), &p.RiskReasons); err != nil {
+			return nil, fmt.Errorf("failed to decode risk reasons: %w", err)
+		}
+		p.CreatedAt = createdAt.Format(time.RFC3339)
+		response.Payments = append(response.Payments, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list payments for review: %w", err)
+	}
+	return response, nil
+}
+
+// ReviewPayment settles a payment held for review. An approved payment is
+// authorized with its provider as if it had passed the screening, so it may
+// still end INSUFFICIENT_FUNDS or DECLINED; a rejected one is DECLINED. The
+// outcome is published on payment.completed like any other payment result.
+// A remote provider is asked once the decision is committed, see
+// SettleAuthorization.
+func (s *Server) ReviewPayment(ctx context.Context, req *payment.ReviewPaymentRequest) (*payment.ProcessPaymentResponse, error) {
+	decision := strings.ToUpper(req.Decision)
+	if decision != reviewApprove && decision != reviewReject {
+		return nil, status.Errorf(codes.InvalidArgument, "decision must be %s or %s", reviewApprove, reviewReject)
+	}
+	if req.Reviewer == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "reviewer is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := lockPayment(ctx, tx, `WHERE id = $1`, req.PaymentId)
+	if errors.Is(err, errPaymentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "payment %s not found", req.PaymentId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.status != StatusReview {
+		return nil, status.Errorf(codes.FailedPrecondition, "payment %s is %s, not waiting for review", p.id, p.status)
+	}
+
+	result := authorization{status: StatusDeclined, message: "Rejected in review by " + req.Reviewer}
+	key := "review:" + p.id
+	if decision == reviewApprove {
+		prov, err := s.providers.Get(p.provider)
+		if err == nil && provider.IsRemote(prov) {
+			result = authorizeLater(p.provider)
+		} else {
+			result, err = s.authorizePayment(ctx, tx, p.provider, provider.Payment{
+				Id:             p.id,
+				OrderId:        p.orderId,
+				UserId:         p.userId,
+				Amount:         p.amount,
+				Currency:       p.currency,
+				IdempotencyKey: key,
+			})
+			if errors.Is(err, provider.ErrUnavailable) {
+				return nil, status.Errorf(codes.Unavailable, "%v", err)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE payments SET status = $1, provider_ref = $2, charged_amount = $3, charged_currency = $4, fx_rate = $5,
+		                     expires_at = $6, reviewed_by = $7, review_note = NULLIF($8, ''), reviewed_at = $9,
+		                     authorization_key = $10
+		 WHERE id = $11`,
+		result.status, result.reference, result.chargedAmount, result.chargedCurrency, result.rate,
+		result.expiresAt, req.Reviewer, req.Note, time.Now(), key, p.id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update payment: %w", err)
+	}
+
+	if result.status != StatusAuthorizing {
+		err = enqueueCompleted(ctx, tx, structs.PaymentCompletedEvent{
+			OrderId:   p.orderId,
+			PaymentId: p.id,
+			UserId:    p.userId,
+			Amount:    p.amount,
+			Status:    result.status,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	resp := &payment.ProcessPaymentResponse{
+		PaymentId: p.id,
+		Status:    result.status,
+		Message:   result.message,
+	}
+	if result.status == StatusAuthorizing {
+		resp, err = s.SettleAuthorization(ctx, p.id)
+		if errors.Is(err, provider.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%v", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Payment %s for order %s reviewed by %s: %s, now %s", p.id, p.orderId, req.Reviewer, decision, resp.Status)
+	return resp, nil
+}
@@ -7,141 +7,480 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/5rfy/micro-delivery/pkg/outbox"
 	"github.com/5rfy/micro-delivery/proto/generated/payment"
-	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/fx"
+	"main.go/ledger"
+	"main.go/limits"
+	"main.go/provider"
+	"main.go/risk"
 	"main.go/structs"
 )
 
-const TopicPaymentCompleted = "payment.completed"
+const (
+	TopicPaymentCompleted = "payment.completed"
+	TopicPaymentRefunded  = "payment.refunded"
+	TopicPaymentCaptured  = "payment.captured"
+)
+
+// Payment statuses. An AUTHORIZED payment holds the amount; capturing it
+// makes it SUCCESS, while releasing the hold ends it RELEASED or, when the
+// hold ran out, EXPIRED. A payment the provider refused is INSUFFICIENT_FUNDS
+// or DECLINED. A payment the risk screening flagged waits in REVIEW, with
+// nothing reserved, until a reviewer approves or rejects it. A payment over
+// the user's spending limits is LIMIT_EXCEEDED. A payment with a remote
+// provider is AUTHORIZING from when it is stored until the provider's answer
+// is, see SettleAuthorization.
+const (
+	StatusAuthorizing       = "AUTHORIZING"
+	StatusAuthorized        = "AUTHORIZED"
+	StatusReview            = "REVIEW"
+	StatusLimitExceeded     = "LIMIT_EXCEEDED"
+	StatusInsufficientFunds = "INSUFFICIENT_FUNDS"
+	StatusDeclined          = "DECLINED"
+	StatusSuccess           = "SUCCESS"
+	StatusReleased          = "RELEASED"
+	StatusExpired           = "EXPIRED"
+	StatusPartiallyRefunded = "PARTIALLY_REFUNDED"
+	StatusRefunded          = "REFUNDED"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const defaultCurrency = "USD"
+
+// successfulStatuses are the statuses of a payment that went through. An
+// order has at most one payment in them.
+const successfulStatuses = `('AUTHORIZED', 'SUCCESS', 'PARTIALLY_REFUNDED', 'REFUNDED')`
 
 type Server struct {
 	payment.UnimplementedPaymentServiceServer
-	db       *sql.DB
-	producer sarama.SyncProducer
+	db        *sql.DB
+	wallet    WalletPolicy
+	providers provider.Registry
+	risk      *risk.Engine
+	limits    *limits.Checker
 }
 
-func NewServer(db *sql.DB, producer sarama.SyncProducer) *Server {
-	return &Server{db: db, producer: producer}
+func NewServer(db *sql.DB, wallet WalletPolicy, providers provider.Registry, risk *risk.Engine, limits *limits.Checker) *Server {
+	return &Server{db: db, wallet: wallet, providers: providers, risk: risk, limits: limits}
 }
 
 func (s *Server) ProcessPayment(ctx context.Context, req *payment.ProcessPaymentRequest) (*payment.ProcessPaymentResponse, error) {
-	paymentId := uuid.New().String()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	var balance decimal.Decimal
-	err := s.db.QueryRowContext(ctx,
-		`SELECT balance FROM user_balances WHERE user_id = $1`,
-		req.UserId,
-	).Scan(&balance)
+	resp, err := s.ProcessPaymentTx(ctx, tx, req)
+	if errors.Is(err, provider.ErrUnavailable) {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	if errors.Is(err, errIdempotencyKeyReused) {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	if errors.Is(err, sql.ErrNoRows) {
-		balance = decimal.NewFromFloat(1000.0)
-		s.db.ExecContext(ctx,
-			`INSERT INTO user_balances (user_id, balance) VALUES ($1, $2)`,
-			req.UserId, balance,
-		)
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to check balance: %w", err)
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	var status, msg string
-	cost := decimal.NewFromFloat(req.Amount)
+	if resp.Status == StatusAuthorizing {
+		resp, err = s.SettleAuthorization(ctx, resp.PaymentId)
+		if errors.Is(err, provider.ErrUnavailable) {
+			return nil, status.Errorf(codes.Unavailable, "%v", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
 
-	if cost.GreaterThan(balance) {
-		status = "INSUFFICIENT_FUNDS"
-		msg = fmt.Sprintf("Insufficient funds. Balance: %s, Required: %s", balance, cost)
+// ProcessPaymentTx checks the payment against the user's spending limits and
+// screens it for risk, authorizes it with the requested provider, the wallet
+// by default, and stores it together with its payment.completed event inside
+// tx. A payment over the limits or flagged by the screening does not reach
+// the provider. The money moves only when the payment is captured. A provider
+// that cannot be reached fails the call with provider.ErrUnavailable, so it is
+// retried. The caller owns the commit.
+//
+// A remote provider is not called inside tx, where the user's spending stays
+// locked: the payment is stored AUTHORIZING, without its payment.completed
+// event, and the caller settles it with SettleAuthorization once tx is
+// committed.
+//
+// Requests are idempotent: a request repeating an idempotency key, the order
+// id when none is given, gets the response of the first one back, and an
+// order that already has a successful payment is not charged again.
+func (s *Server) ProcessPaymentTx(ctx context.Context, tx *sql.Tx, req *payment.ProcessPaymentRequest) (*payment.ProcessPaymentResponse, error) {
+	providerName := req.Provider
+	if providerName == "" {
+		providerName = provider.Wallet
+	}
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	key := req.IdempotencyKey
+	if key == "" {
+		key = req.OrderId
+	}
 
-		_, err := s.db.ExecContext(ctx,
-			`INSERT INTO payments (id, order_id, user_id, amount, status, created_at)
-			 VALUES ($1, $2, $3, $4, $5, $6)`,
-			paymentId, req.OrderId, req.UserId, req.Amount, status, time.Now(),
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to insert payment: %w", err)
+	// Requests for the same order queue up here, so the checks for an
+	// earlier response and an earlier payment cannot race.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, req.OrderId); err != nil {
+		return nil, fmt.Errorf("failed to lock order: %w", err)
+	}
+
+	fingerprint := requestFingerprint(req, providerName, currency)
+	previous, err := replayResponse(ctx, tx, key, fingerprint)
+	if err != nil || previous != nil {
+		return previous, err
+	}
+
+	resp, err := s.processPaymentTx(ctx, tx, req, providerName, currency, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveResponse(ctx, tx, key, req.OrderId, fingerprint, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *Server) processPaymentTx(ctx context.Context, tx *sql.Tx, req *payment.ProcessPaymentRequest, providerName string, currency string, key string) (*payment.ProcessPaymentResponse, error) {
+	paymentId := uuid.New().String()
+
+	var cancelled bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM cancelled_orders WHERE order_id = $1)`,
+		req.OrderId,
+	).Scan(&cancelled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check cancellation: %w", err)
+	}
+	if cancelled {
+		return &payment.ProcessPaymentResponse{
+			Status:  "CANCELLED",
+			Message: fmt.Sprintf("Order %s was cancelled, nothing charged", req.OrderId),
+		}, nil
+	}
+
+	var paid payment.ProcessPaymentResponse
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, status FROM payments WHERE order_id = $1 AND (status IN `+successfulStatuses+` OR status IN ($2, $3))`,
+		req.OrderId, StatusReview, StatusAuthorizing,
+	).Scan(&paid.PaymentId, &paid.Status)
+	if err == nil {
+		paid.Message = fmt.Sprintf("Order %s is already paid by payment %s", req.OrderId, paid.PaymentId)
+		switch paid.Status {
+		case StatusReview:
+			paid.Message = fmt.Sprintf("Payment %s of order %s is waiting for review", paid.PaymentId, req.OrderId)
+		case StatusAuthorizing:
+			paid.Message = fmt.Sprintf("Payment %s of order %s is being authorized", paid.PaymentId, req.OrderId)
+		}
+		return &paid, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to check earlier payments: %w", err)
+	}
+
+	// Spending limits add payments up in fx.Base at the rate of the day they
+	// were made, so a payment in a currency without a rate to it is declined
+	// before it is screened and counted.
+	cost := decimal.NewFromFloat(req.Amount)
+	var result authorization
+	var assessment risk.Assessment
+	var baseRate decimal.NullDecimal
+	rate, err := fx.GetRate(ctx, tx, currency, fx.Base)
+	switch {
+	case errors.Is(err, fx.ErrNoRate):
+		result = authorization{
+			status:  StatusDeclined,
+			message: fmt.Sprintf("No exchange rate from %s to %s", currency, fx.Base),
 		}
-	} else {
-		tx, _ := s.db.BeginTx(ctx, nil)
-		defer tx.Rollback()
-
-		_, err := tx.ExecContext(ctx,
-			`UPDATE user_balances SET balance = balance - $1 WHERE user_id = $2`,
-			req.Amount, req.UserId,
-		)
+	case err != nil:
+		return nil, err
+	default:
+		baseRate = decimal.NewNullDecimal(rate)
+		result, assessment, err = s.screenAndAuthorize(ctx, tx, providerName, req.DeliveryAddress, provider.Payment{
+			Id:             paymentId,
+			OrderId:        req.OrderId,
+			UserId:         req.UserId,
+			Amount:         cost,
+			Currency:       currency,
+			IdempotencyKey: key,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to debuct balance: %w", err)
+			return nil, err
 		}
+	}
 
-		_, err = tx.ExecContext(ctx,
-			`INSERT INTO payments (id, order_id, user_id, amount, status, created_at)
-			 VALUES ($1, $2, $3, $4, $5, $6)`,
-			paymentId, req.OrderId, req.UserId, req.Amount, status, time.Now(),
-		)
+	// A payment stopped by its limits was never screened for risk.
+	var riskScore sql.NullInt32
+	var riskDecision, riskReasons sql.NullString
+	if assessment.Decision != "" {
+		reasons, err := json.Marshal(assessment.Reasons)
 		if err != nil {
-			return nil, fmt.Errorf("failed to insert payment: %w", err)
+			return nil, fmt.Errorf("failed to marshal risk reasons: %w", err)
 		}
+		riskScore = sql.NullInt32{Int32: int32(assessment.Score), Valid: true}
+		riskDecision = sql.NullString{String: assessment.Decision, Valid: true}
+		riskReasons = sql.NullString{String: string(reasons), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO payments (id, order_id, user_id, amount, currency, status, provider, provider_ref,
+		                       charged_amount, charged_currency, fx_rate, expires_at,
+		                       risk_score, risk_decision, risk_reasons, base_rate, authorization_key, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+		paymentId, req.OrderId, req.UserId, req.Amount, currency, result.status, providerName, result.reference,
+		result.chargedAmount, result.chargedCurrency, result.rate, result.expiresAt,
+		riskScore, riskDecision, riskReasons, baseRate, key, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert payment: %w", err)
+	}
 
-		err = tx.Commit()
-
+	if result.status != StatusAuthorizing {
+		err = enqueueCompleted(ctx, tx, structs.PaymentCompletedEvent{
+			OrderId:   req.OrderId,
+			PaymentId: paymentId,
+			UserId:    req.UserId,
+			Amount:    cost,
+			Status:    result.status,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+			return nil, err
 		}
+	}
 
-		status = "SUCCESS"
-		msg = fmt.Sprintf("Payment of %s processed successfully", cost)
+	return &payment.ProcessPaymentResponse{
+		PaymentId:    paymentId,
+		Status:       result.status,
+		Message:      result.message,
+		RiskScore:    int32(assessment.Score),
+		RiskDecision: assessment.Decision,
+	}, nil
+}
+
+// screenAndAuthorize checks p against the user's spending limits and the
+// risk rules, and authorizes it with the provider called providerName if it
+// passes both. Only approved payments reach the provider; one held for review
+// is authorized when a reviewer approves it.
+func (s *Server) screenAndAuthorize(ctx context.Context, tx *sql.Tx, providerName string, deliveryAddress string, p provider.Payment) (authorization, risk.Assessment, error) {
+	err := s.limits.Check(ctx, tx, p.UserId, p.Amount, p.Currency)
+	if errors.Is(err, limits.ErrExceeded) {
+		return authorization{status: StatusLimitExceeded, message: err.Error()}, risk.Assessment{}, nil
+	}
+	if err != nil {
+		return authorization{}, risk.Assessment{}, err
 	}
 
-	event := structs.PaymentCompletedEvent{
-		OrderId:   req.OrderId,
-		PaymentId: paymentId,
-		UserId:    req.UserId,
-		Amount:    cost,
-		Status:    status,
+	assessment, err := s.risk.Assess(ctx, tx, risk.Payment{
+		UserId:          p.UserId,
+		Amount:          p.Amount,
+		Currency:        p.Currency,
+		DeliveryAddress: deliveryAddress,
+	})
+	if err != nil {
+		return authorization{}, risk.Assessment{}, err
+	}
+
+	switch assessment.Decision {
+	case risk.Decline:
+		return authorization{status: StatusDeclined, message: "Declined by risk screening: " + strings.Join(assessment.Reasons, "; ")}, assessment, nil
+	case risk.Review:
+		return authorization{status: StatusReview, message: "Held for manual review: " + strings.Join(assessment.Reasons, "; ")}, assessment, nil
+	}
+	if prov, err := s.providers.Get(providerName); err == nil && provider.IsRemote(prov) {
+		return authorizeLater(providerName), assessment, nil
 	}
-	payload, _ := json.Marshal(event)
+	result, err := s.authorizePayment(ctx, tx, providerName, p)
+	return result, assessment, err
+}
 
-	go s.publishEvent(TopicPaymentCompleted, req.OrderId, payload)
+// authorizeLater is the result of a payment waiting to be authorized by the
+// remote provider called providerName.
+func authorizeLater(providerName string) authorization {
+	return authorization{status: StatusAuthorizing, message: "Waiting for " + providerName + " to authorize the payment"}
+}
 
-	return &payment.ProcessPaymentResponse{
-		PaymentId: paymentId,
-		Status:    status,
-		Message:   msg,
+// authorization is the outcome of asking a provider to authorize a payment,
+// in the form it is stored in the payment row.
+type authorization struct {
+	status          string
+	message         string
+	reference       sql.NullString
+	chargedAmount   decimal.NullDecimal
+	chargedCurrency sql.NullString
+	rate            decimal.NullDecimal
+	expiresAt       sql.NullTime
+}
+
+// authorizePayment asks the provider called providerName to authorize p. A
+// refusal is an INSUFFICIENT_FUNDS or DECLINED result rather than an error.
+func (s *Server) authorizePayment(ctx context.Context, tx *sql.Tx, providerName string, p provider.Payment) (authorization, error) {
+	auth, err := s.authorize(ctx, tx, providerName, p)
+	return authorizationOf(providerName, p, auth, err)
+}
+
+// authorizationOf turns the answer of the provider called providerName to
+// authorizing p into the result stored in the payment.
+func authorizationOf(providerName string, p provider.Payment, auth provider.Authorization, err error) (authorization, error) {
+	var declined *provider.DeclinedError
+	if errors.As(err, &declined) {
+		status := StatusDeclined
+		if declined.Code == provider.CodeInsufficientFunds {
+			status = StatusInsufficientFunds
+		}
+		return authorization{status: status, message: declined.Message}, nil
+	}
+	if err != nil {
+		return authorization{}, err
+	}
+
+	msg := fmt.Sprintf("Payment of %s %s authorized by %s, captured once the parcel is in transit", p.Amount, p.Currency, providerName)
+	if auth.Currency != p.Currency {
+		msg += fmt.Sprintf(" (charged %s %s at %s)", auth.Amount, auth.Currency, auth.Rate)
+	}
+	return authorization{
+		status:          StatusAuthorized,
+		message:         msg,
+		reference:       sql.NullString{String: auth.Reference, Valid: true},
+		chargedAmount:   decimal.NewNullDecimal(auth.Amount),
+		chargedCurrency: sql.NullString{String: auth.Currency, Valid: true},
+		rate:            decimal.NewNullDecimal(auth.Rate),
+		expiresAt:       sql.NullTime{Time: auth.ExpiresAt, Valid: true},
 	}, nil
 }
 
+func (s *Server) authorize(ctx context.Context, tx *sql.Tx, providerName string, p provider.Payment) (provider.Authorization, error) {
+	prov, err := s.providers.Get(providerName)
+	if err != nil {
+		return provider.Authorization{}, err
+	}
+	return prov.Authorize(ctx, tx, p)
+}
+
+func enqueueCompleted(ctx context.Context, tx *sql.Tx, event structs.PaymentCompletedEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return outbox.Enqueue(ctx, tx, TopicPaymentCompleted, event.OrderId, payload)
+}
+
 func (s *Server) GetPaymentStatus(ctx context.Context, req *payment.GetPaymentStatusRequest) (*payment.GetPaymentStatusResponse, error) {
 	var res payment.GetPaymentStatusResponse
 	var paidAt time.Time
+	var reference, chargedCurrency, riskDecision, riskReasons sql.NullString
+	var chargedAmount, rate decimal.NullDecimal
+	var riskScore sql.NullInt32
 
+	// The order's successful payment if it has one, else its latest attempt.
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, order_id, status, amount, created_at 
-		FROM payments 
-		WHERE order_id = $1 ORDER BY created_at DESC LIMIT 1`,
+		`SELECT id, order_id, status, amount, currency, provider, provider_ref,
+		        charged_amount, charged_currency, fx_rate, risk_score, risk_decision, risk_reasons,
+		        COALESCE(reviewed_by, ''), COALESCE(review_note, ''), created_at
+		FROM payments
+		WHERE order_id = $1 ORDER BY status IN `+successfulStatuses+` DESC, created_at DESC LIMIT 1`,
 		req.OrderId,
-	).Scan(&res.PaymentId, &res.Status, &res.Amount, &paidAt)
+	).Scan(&res.PaymentId, &res.OrderId, &res.Status, &res.Amount, &res.Currency, &res.Provider, &reference,
+		&chargedAmount, &chargedCurrency, &rate, &riskScore, &riskDecision, &riskReasons,
+		&res.ReviewedBy, &res.ReviewNote, &paidAt)
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("payment for order %s not found", req.OrderId)
+		return nil, status.Errorf(codes.NotFound, "payment for order %s not found", req.OrderId)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get payment status: %w", err)
 	}
 
 	res.PaidAt = paidAt.Format(time.RFC3339)
+	if chargedAmount.Valid {
+		res.ChargedAmount = chargedAmount.Decimal.InexactFloat64()
+		res.ChargedCurrency = chargedCurrency.String
+		res.FxRate = rate.Decimal.InexactFloat64()
+	}
+	if riskDecision.Valid {
+		res.RiskScore = riskScore.Int32
+		res.RiskDecision = riskDecision.String
+		if err := json.Unmarshal([]byte(riskReasons.String), &res.RiskReasons); err != nil {
+			return nil, fmt.Errorf("failed to decode risk reasons: %w", err)
+		}
+	}
+	if reference.Valid {
+		// The provider's view is informational; the payment row stays the
+		// source of truth when the provider cannot be reached.
+		if prov, err := s.providers.Get(res.Provider); err == nil {
+			res.ProviderStatus, err = prov.Status(ctx, reference.String)
+			if err != nil {
+				log.Printf("Failed to get %s status of payment %s: %v", res.Provider, res.PaymentId, err)
+			}
+		}
+	}
 	return &res, nil
 }
 
-func (s *Server) publishEvent(eventTopic string, orderId string, payload []byte) {
-	msg := &sarama.ProducerMessage{
-		Topic: eventTopic,
-		Key:   sarama.StringEncoder(orderId),
-		Value: sarama.ByteEncoder(payload),
+func (s *Server) GetLedger(ctx context.Context, req *payment.GetLedgerRequest) (*payment.GetLedgerResponse, error) {
+	pageSize, before, err := parsePage(req.PageSize, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, err := walletCurrency(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	account := ledger.UserAccount(req.UserId, currency)
+	balance, err := ledger.Balance(ctx, s.db, account)
+	if errors.Is(err, ledger.ErrAccountNotFound) {
+		return nil, status.Errorf(codes.NotFound, "account of user %s not found", req.UserId)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	_, _, err := s.producer.SendMessage(msg)
+	lines, err := ledger.Statement(ctx, s.db, account, nil, before, pageSize+1)
 	if err != nil {
-		log.Printf("Failed to publish to %s: %v", eventTopic, err)
+		return nil, err
+	}
+
+	response := &payment.GetLedgerResponse{
+		UserId:    req.UserId,
+		AccountId: account,
+		Currency:  currency,
+		Balance:   balance.InexactFloat64(),
+	}
+	if len(lines) > pageSize {
+		lines = lines[:pageSize]
+		response.NextCursor = strconv.FormatInt(lines[pageSize-1].PostingId, 10)
+	}
+	for _, l := range lines {
+		response.Lines = append(response.Lines, &payment.LedgerLine{
+			EntryId:      l.EntryId,
+			Type:         l.Type,
+			Reference:    l.Reference,
+			Description:  l.Description,
+			Amount:       l.Amount.InexactFloat64(),
+			BalanceAfter: l.BalanceAfter.InexactFloat64(),
+			CreatedAt:    l.CreatedAt.Format(time.RFC3339),
+		})
 	}
+	return response, nil
 }
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"main.go/database"
+	"main.go/ledger"
+	"main.go/limits"
+	"main.go/provider"
+	"main.go/risk"
+)
+
+// newServer returns a server paying from wallets opened with balance, with
+// no risk rules and no spending limits unless given.
+func newServer(t *testing.T, balance decimal.Decimal, spending limits.Limits) (*Server, *sql.DB) {
+	db := database.InitDb(testdb.New(t))
+	t.Cleanup(func() { db.Close() })
+
+	wallet := WalletPolicy{InitialBalance: balance, HoldTTL: DefaultWalletPolicy.HoldTTL}
+	providers := provider.Registry{
+		provider.Wallet: provider.NewWallet(db, wallet.InitialBalance, wallet.HoldTTL),
+	}
+	return NewServer(db, wallet, providers, risk.NewEngine(risk.Rules{}), limits.NewChecker(spending)), db
+}
+
+func TestPaymentInUnratedCurrencyIsDeclinedAndNotCounted(t *testing.T) {
+	s, _ := newServer(t, DefaultWalletPolicy.InitialBalance, limits.Limits{Daily: decimal.NewFromInt(500)})
+	ctx := context.Background()
+
+	resp, err := s.ProcessPayment(ctx, &payment.ProcessPaymentRequest{
+		OrderId: uuid.New().String(), UserId: "user-1", Amount: 10, Currency: "XYZ",
+	})
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if resp.Status != StatusDeclined {
+		t.Fatalf("status = %s, want %s", resp.Status, StatusDeclined)
+	}
+
+	// The declined payment must not break the spending checks of the user.
+	resp, err = s.ProcessPayment(ctx, &payment.ProcessPaymentRequest{
+		OrderId: uuid.New().String(), UserId: "user-1", Amount: 10, Currency: "USD",
+	})
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if resp.Status != StatusAuthorized {
+		t.Fatalf("status = %s, want %s", resp.Status, StatusAuthorized)
+	}
+	spending, err := s.GetSpendingLimits(ctx, &payment.GetSpendingLimitsRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("GetSpendingLimits: %v", err)
+	}
+	if spending.Spent != 10 {
+		t.Fatalf("spent = %v, want 10", spending.Spent)
+	}
+}
+
+// payInParallel fires payments of amount for user-1 at once, each for an
+// order of its own, and returns how many of them were authorized. A payment
+// may only be refused with refused.
+func payInParallel(t *testing.T, s *Server, payments int, amount float64, refused string) int {
+	ctx := context.Background()
+	start := make(chan struct{})
+	statuses := make(chan string, payments)
+	errs := make(chan error, payments)
+
+	var wg sync.WaitGroup
+	for i := 0; i < payments; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			resp, err := s.ProcessPayment(ctx, &payment.ProcessPaymentRequest{
+				OrderId: uuid.New().String(), UserId: "user-1", Amount: amount, Currency: "USD",
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			statuses <- resp.Status
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(statuses)
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	authorized := 0
+	for status := range statuses {
+		switch status {
+		case StatusAuthorized:
+			authorized++
+		case refused:
+		default:
+			t.Fatalf("payment status = %s, want %s or %s", status, StatusAuthorized, refused)
+		}
+	}
+	return authorized
+}
+
+func TestConcurrentWalletPaymentsNeverOverdraw(t *testing.T) {
+	s, db := newServer(t, decimal.NewFromInt(100), limits.Limits{})
+
+	authorized := payInParallel(t, s, 40, 7, StatusInsufficientFunds)
+	if authorized != 14 {
+		t.Fatalf("%d payments authorized, want 14", authorized)
+	}
+
+	available, held, err := ledger.Available(context.Background(), db, ledger.UserAccount("user-1", ledger.BaseCurrency))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available.IsNegative() {
+		t.Fatalf("available balance is %s", available)
+	}
+	if want := decimal.NewFromInt(98); !held.Equal(want) {
+		t.Fatalf("held = %s, want %s", held, want)
+	}
+}
+
+func TestConcurrentPaymentsStayWithinTheDailyLimit(t *testing.T) {
+	s, _ := newServer(t, decimal.NewFromInt(1000), limits.Limits{Daily: decimal.NewFromInt(50)})
+
+	authorized := payInParallel(t, s, 40, 7, StatusLimitExceeded)
+	if authorized != 7 {
+		t.Fatalf("%d payments authorized, want 7", authorized)
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/5rfy/micro-delivery/proto/generated/payment"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main.go/ledger"
+)
+
+// WalletPolicy controls how user wallets are funded and how long payments
+// may hold money in them.
+type WalletPolicy struct {
+	// InitialBalance is granted as an opening entry when a user's wallet in
+	// the base currency is opened, on the first payment or deposit. Zero
+	// opens an empty wallet. Wallets in other currencies open empty.
+	InitialBalance decimal.Decimal
+	// HoldTTL is how long an authorized payment keeps its hold before the
+	// hold is released automatically.
+	HoldTTL time.Duration
+}
+
+var DefaultWalletPolicy = WalletPolicy{
+	InitialBalance: decimal.NewFromInt(1000),
+	HoldTTL:        24 * time.Hour,
+}
+
+// openingBalance is what a newly opened wallet in currency is funded with.
+func (s *Server) openingBalance(currency string) decimal.Decimal {
+	if currency == ledger.BaseCurrency {
+		return s.wallet.InitialBalance
+	}
+	return decimal.Zero
+}
+
+// walletCurrency validates a wallet currency code, defaulting to the base currency.
+func walletCurrency(currency string) (string, error) {
+	if currency == "" {
+		return ledger.BaseCurrency, nil
+	}
+	currency = strings.ToUpper(currency)
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", status.Errorf(codes.InvalidArgument, "currency must be a three-letter ISO 4217 code")
+	}
+	return currency, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *payment.GetBalanceRequest) (*payment.GetBalanceResponse, error) {
+	currency, err := walletCurrency(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	account := ledger.UserAccount(req.UserId, currency)
+	balance, err := ledger.Balance(ctx, s.db, account)
+	if errors.Is(err, ledger.ErrAccountNotFound) {
+		return nil, status.Errorf(codes.NotFound, "%s wallet of user %s not found", currency, req.UserId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	available, held, err := ledger.Available(ctx, s.db, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment.GetBalanceResponse{
+		UserId:    req.UserId,
+		Currency:  currency,
+		Balance:   balance.InexactFloat64(),
+		Available: available.InexactFloat64(),
+		Held:      held.InexactFloat64(),
+	}, nil
+}
+
+// Deposit tops up a user's wallet, opening it first if needed.
+func (s *Server) Deposit(ctx context.Context, req *payment.DepositRequest) (*payment.DepositResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id is required")
+	}
+	amount := decimal.NewFromFloat(req.Amount)
+	if !amount.IsPositive() || !amount.Equal(amount.Round(2)) {
+		return nil, status.Errorf(codes.InvalidArgument, "amount must be positive with at most two decimal places")
+	}
+	description := req.Description
+	if description == "" {
+		description = "Wallet top-up"
+	}
+	currency, err := walletCurrency(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := ledger.OpenUserAccount(ctx, tx, req.UserId, currency, s.openingBalance(currency)); err != nil {
+		return nil, err
+	}
+
+	account := ledger.UserAccount(req.UserId, currency)
+	entryId, err := ledger.Post(ctx, tx, ledger.EntryDeposit, "", description,
+		ledger.Posting{AccountId: ledger.SystemAccount(ledger.AccountDeposits, currency), Amount: amount.Neg()},
+		ledger.Posting{AccountId: account, Amount: amount},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := ledger.Balance(ctx, tx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &payment.DepositResponse{
+		TransactionId: entryId,
+		Currency:      currency,
+		Balance:       balance.InexactFloat64(),
+	}, nil
+}
+
+func (s *Server) ListTransactions(ctx context.Context, req *payment.ListTransactionsRequest) (*payment.ListTransactionsResponse, error) {
+	pageSize, before, err := parsePage(req.PageSize, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, err := walletCurrency(req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]string, 0, len(req.Types))
+	for _, t := range req.Types {
+		types = append(types, strings.ToUpper(t))
+	}
+
+	lines, err := ledger.Statement(ctx, s.db, ledger.UserAccount(req.UserId, currency), types, before, pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &payment.ListTransactionsResponse{}
+	if len(lines) > pageSize {
+		lines = lines[:pageSize]
+		response.NextCursor = strconv.FormatInt(lines[pageSize-1].PostingId, 10)
+	}
+	for _, l := range lines {
+		response.Transactions = append(response.Transactions, &payment.Transaction{
+			TransactionId: l.EntryId,
+			Type:          l.Type,
+			Reference:     l.Reference,
+			Description:   l.Description,
+			Amount:        l.Amount.InexactFloat64(),
+			BalanceAfter:  l.BalanceAfter.InexactFloat64(),
+			CreatedAt:     l.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return response, nil
+}
+
+// parsePage applies the page size limits and decodes a statement cursor,
+// which is the id of the last posting on the previous page.
+func parsePage(size int32, cursor string) (int, int64, error) {
+	pageSize := int(size)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	var before int64
+	if cursor != "" {
+		var err error
+		before, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return 0, 0, status.Errorf(codes.InvalidArgument, "invalid cursor")
+		}
+	}
+	return pageSize, before, nil
+}
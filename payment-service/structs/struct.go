@@ -2,11 +2,17 @@ package structs
 
 import "github.com/shopspring/decimal"
 
-type OrderCreatedEvent struct {
+type PaymentCommand struct {
+	CommandId       string          `json:"command_id"`
+	Type            string          `json:"type"`
 	OrderId         string          `json:"order_id"`
 	UserId          string          `json:"user_id"`
-	TotalAmount     decimal.Decimal `json:"total_amount"`
+	Amount          decimal.Decimal `json:"amount"`
+	Currency        string          `json:"currency"`
+	PaymentMethod   string          `json:"payment_method"`
 	DeliveryAddress string          `json:"delivery_address"`
+	Reason          string          `json:"reason"`
+	Status          string          `json:"status"`
 }
 
 type PaymentCompletedEvent struct {
@@ -16,3 +22,23 @@ type PaymentCompletedEvent struct {
 	Amount    decimal.Decimal `json:"amount"`
 	Status    string          `json:"status"`
 }
+
+type PaymentRefundedEvent struct {
+	OrderId       string          `json:"order_id"`
+	PaymentId     string          `json:"payment_id"`
+	RefundId      string          `json:"refund_id"`
+	UserId        string          `json:"user_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	RefundedTotal decimal.Decimal `json:"refunded_total"`
+	Reason        string          `json:"reason"`
+	Status        string          `json:"status"`
+}
+
+type PaymentCapturedEvent struct {
+	OrderId   string          `json:"order_id"`
+	PaymentId string          `json:"payment_id"`
+	UserId    string          `json:"user_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason"`
+}
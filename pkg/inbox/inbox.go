@@ -0,0 +1,61 @@
+// Package inbox deduplicates consumed messages, so a message delivered more
+// than once takes effect once.
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/5rfy/micro-delivery/pkg/outbox"
+	"github.com/5rfy/micro-delivery/pkg/retry"
+	"github.com/IBM/sarama"
+)
+
+// Handle runs fn in a transaction that also records eventId in processed_events.
+// When the event has already been processed fn is not called and Handle
+// returns false, so a redelivered message never repeats its side effects.
+func Handle(ctx context.Context, db *sql.DB, eventId string, topic string, fn func(tx *sql.Tx) error) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO processed_events (event_id, topic, processed_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (event_id) DO NOTHING`,
+		eventId, topic, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to record processed event: %w", err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record processed event: %w", err)
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	if err := fn(tx); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// EventId identifies a message for deduplication: the outbox id set by the
+// producer, or the coordinates of the original message if the header is missing.
+func EventId(msg *sarama.ConsumerMessage) string {
+	if id := retry.Header(msg, outbox.HeaderEventId); id != "" {
+		return id
+	}
+	return fmt.Sprintf("%s-%s-%s", retry.SourceTopic(msg),
+		retry.OriginalValue(msg, retry.HeaderOriginalPartition, int64(msg.Partition)),
+		retry.OriginalValue(msg, retry.HeaderOriginalOffset, msg.Offset))
+}
@@ -0,0 +1,111 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/5rfy/micro-delivery/pkg/testdb"
+)
+
+func openDb(t *testing.T) *sql.DB {
+	db := testdb.Open(t)
+	for _, migration := range []string{
+		`CREATE TABLE processed_events (
+			event_id VARCHAR(255) PRIMARY KEY,
+			topic VARCHAR(255) NOT NULL,
+			processed_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE effects (event_id VARCHAR(255) NOT NULL)`,
+	} {
+		if _, err := db.Exec(migration); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+	}
+	return db
+}
+
+func apply(eventId string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO effects (event_id) VALUES ($1)`, eventId)
+		return err
+	}
+}
+
+func countEffects(t *testing.T, db *sql.DB) int {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM effects`).Scan(&n); err != nil {
+		t.Fatalf("failed to count effects: %v", err)
+	}
+	return n
+}
+
+func TestHandleAppliesAReplayedMessageOnce(t *testing.T) {
+	db := openDb(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		processed, err := Handle(ctx, db, "event-1", "orders", apply("event-1"))
+		if err != nil {
+			t.Fatalf("delivery %d: %v", i, err)
+		}
+		if processed != (i == 0) {
+			t.Fatalf("delivery %d: processed = %v", i, processed)
+		}
+	}
+	if n := countEffects(t, db); n != 1 {
+		t.Fatalf("effects = %d, want 1", n)
+	}
+}
+
+func TestHandleAppliesConcurrentReplaysOnce(t *testing.T) {
+	db := openDb(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Handle(ctx, db, "event-1", "orders", apply("event-1")); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if n := countEffects(t, db); n != 1 {
+		t.Fatalf("effects = %d, want 1", n)
+	}
+}
+
+func TestHandleLetsAFailedMessageBeRetried(t *testing.T) {
+	db := openDb(t)
+	ctx := context.Background()
+
+	failure := errors.New("broker down")
+	_, err := Handle(ctx, db, "event-1", "orders", func(tx *sql.Tx) error {
+		if err := apply("event-1")(tx); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+
+	processed, err := Handle(ctx, db, "event-1", "orders", apply("event-1"))
+	if err != nil || !processed {
+		t.Fatalf("retry: processed = %v, err = %v", processed, err)
+	}
+	if n := countEffects(t, db); n != 1 {
+		t.Fatalf("effects = %d, want 1", n)
+	}
+}
@@ -0,0 +1,271 @@
+// Package outbox implements the transactional outbox: events are stored in
+// the same transaction as the change they describe, and a relay publishes
+// them to Kafka afterwards.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+)
+
+// HeaderEventId carries the outbox row id so consumers can deduplicate redeliveries.
+const HeaderEventId = "event_id"
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+	maxBackoff          = 30 * time.Second
+
+	// An event that fails to publish is retried after a delay that doubles
+	// with each attempt up to maxRetryDelay, and parked after maxAttempts.
+	maxAttempts   = 20
+	maxRetryDelay = 5 * time.Minute
+
+	// Sent events are deleted once they are older than retention.
+	retention     = 7 * 24 * time.Hour
+	purgeInterval = time.Hour
+	purgeBatch    = 10000
+)
+
+// Enqueue stores an event in outbox_events as part of the caller's transaction.
+// The relay publishes it to Kafka once the transaction is committed.
+func Enqueue(ctx context.Context, tx *sql.Tx, topic string, key string, payload []byte) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO outbox_events (id, topic, event_key, payload, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		uuid.New().String(), topic, key, string(payload), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox event: %w", err)
+	}
+	return nil
+}
+
+type event struct {
+	id       string
+	topic    string
+	key      string
+	payload  string
+	attempts int
+}
+
+// Relay drains unsent outbox_events into Kafka in creation order.
+// Rows are claimed with FOR UPDATE SKIP LOCKED and an event is only picked
+// once every earlier event with the same key has been sent, so several
+// replicas can run the relay without reordering events of one order.
+//
+// An event that cannot be published holds back only the later events of its
+// key, and is retried with backoff. After maxAttempts it is parked: it gets a
+// parked_at time, is no longer retried and stops holding back its key, so one
+// poison event cannot stall an order forever. Parked events are re-driven by
+// clearing parked_at and attempts.
+type Relay struct {
+	db           *sql.DB
+	producer     sarama.SyncProducer
+	batchSize    int
+	pollInterval time.Duration
+}
+
+func NewRelay(db *sql.DB, producer sarama.SyncProducer) *Relay {
+	return &Relay{
+		db:           db,
+		producer:     producer,
+		batchSize:    defaultBatchSize,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	backoff := r.pollInterval
+	var lastPurge time.Time
+
+	for {
+		if time.Since(lastPurge) >= purgeInterval {
+			if err := r.purge(ctx); err != nil {
+				log.Printf("Outbox purge error: %v", err)
+			}
+			lastPurge = time.Now()
+		}
+
+		claimed, err := r.relayBatch(ctx)
+		if err != nil {
+			log.Printf("Outbox relay error, retrying in %s: %v", backoff, err)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = r.pollInterval
+
+		if claimed == r.batchSize {
+			continue
+		}
+		if !sleep(ctx, r.pollInterval) {
+			return
+		}
+	}
+}
+
+// relayBatch publishes one batch of due events and returns how many it
+// claimed. It fails only if nothing in the batch could be published, which
+// usually means Kafka is down, so Run backs off.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT o.id, o.topic, COALESCE(o.event_key, ''), o.payload, o.attempts
+		 FROM outbox_events o
+		 WHERE o.sent = FALSE AND o.parked_at IS NULL
+		   AND (o.next_attempt_at IS NULL OR o.next_attempt_at <= $2)
+		   AND NOT EXISTS (
+		       SELECT 1 FROM outbox_events p
+		       WHERE p.sent = FALSE AND p.parked_at IS NULL AND p.event_key = o.event_key AND p.seq < o.seq
+		   )
+		 ORDER BY o.seq
+		 LIMIT $1
+		 FOR UPDATE SKIP LOCKED`,
+		r.batchSize, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to select outbox events: %w", err)
+	}
+
+	var events []event
+	for rows.Next() {
+		var e event
+		if err := rows.Scan(&e.id, &e.topic, &e.key, &e.payload, &e.attempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox events: %w", err)
+	}
+
+	sent := 0
+	var publishErr error
+	// Only the first unsent event of a key is claimed, so a failure here
+	// holds back nothing else in the batch.
+	for _, e := range events {
+		msg := &sarama.ProducerMessage{
+			Topic: e.topic,
+			Key:   sarama.StringEncoder(e.key),
+			Value: sarama.StringEncoder(e.payload),
+			Headers: []sarama.RecordHeader{
+				{Key: []byte(HeaderEventId), Value: []byte(e.id)},
+			},
+		}
+
+		if _, _, err := r.producer.SendMessage(msg); err != nil {
+			publishErr = fmt.Errorf("failed to publish event %s to topic %s: %w", e.id, e.topic, err)
+			if err := recordFailure(ctx, tx, e, publishErr); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE outbox_events SET sent = TRUE, sent_at = $1, attempts = attempts + 1, last_error = NULL WHERE id = $2`,
+			time.Now(), e.id); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox event sent: %w", err)
+		}
+		sent++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if sent > 0 {
+		log.Printf("Outbox relay published %d event(s)", sent)
+	}
+	if sent == 0 && publishErr != nil {
+		return len(events), publishErr
+	}
+	if publishErr != nil {
+		log.Printf("Outbox relay published %d of %d event(s), last error: %v", sent, len(events), publishErr)
+	}
+	return len(events), nil
+}
+
+// recordFailure schedules the next attempt of an event that failed to
+// publish, or parks it once it has used up maxAttempts.
+func recordFailure(ctx context.Context, tx *sql.Tx, e event, cause error) error {
+	attempts := e.attempts + 1
+	now := time.Now()
+
+	var parkedAt sql.NullTime
+	if attempts >= maxAttempts {
+		parkedAt = sql.NullTime{Time: now, Valid: true}
+		log.Printf("Outbox event %s to topic %s parked after %d attempts: %v", e.id, e.topic, attempts, cause)
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`UPDATE outbox_events SET attempts = $1, last_error = $2, next_attempt_at = $3, parked_at = $4 WHERE id = $5`,
+		attempts, cause.Error(), now.Add(retryDelay(attempts)), parkedAt, e.id)
+	if err != nil {
+		return fmt.Errorf("failed to record outbox failure: %w", err)
+	}
+	return nil
+}
+
+// retryDelay is the delay before the next attempt of an event that failed
+// attempts times: one second, doubling up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// purge deletes events that were sent more than retention ago, a batch at
+// a time so a large backlog does not hold one long transaction.
+func (r *Relay) purge(ctx context.Context) error {
+	cutoff := time.Now().Add(-retention)
+	total := int64(0)
+	for {
+		res, err := r.db.ExecContext(ctx,
+			`DELETE FROM outbox_events WHERE id IN (
+			     SELECT id FROM outbox_events WHERE sent = TRUE AND sent_at < $1 LIMIT $2
+			 )`,
+			cutoff, purgeBatch)
+		if err != nil {
+			return fmt.Errorf("failed to purge outbox events: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to purge outbox events: %w", err)
+		}
+		total += deleted
+		if deleted < purgeBatch {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("Outbox purged %d sent event(s)", total)
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,372 @@
+// Package retry moves messages a consumer failed to handle onto retry topics
+// with growing delays, and finally onto a dead-letter topic.
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers attached to messages parked on retry and dead-letter topics.
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderAttempt           = "x-attempt"
+	HeaderError             = "x-error"
+	HeaderRetryAt           = "x-retry-at"
+)
+
+// Policy lists the delay before each retry. A message that fails on
+// <topic> goes to <topic>.retry.1, then <topic>.retry.2 and so on; once the
+// delays are exhausted it is parked on <topic>.dlq.
+type Policy struct {
+	Delays []time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Delays: []time.Duration{5 * time.Second, 30 * time.Second, time.Minute},
+}
+
+// Topics returns the source topics together with all their retry topics.
+func (p Policy) Topics(topics ...string) []string {
+	var result []string
+	for _, topic := range topics {
+		result = append(result, topic)
+		for i := range p.Delays {
+			result = append(result, retryTopic(topic, i+1))
+		}
+	}
+	return result
+}
+
+func retryTopic(topic string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", topic, attempt)
+}
+
+func dlqTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix, such as a malformed
+// payload, so the message is sent straight to the dead-letter topic.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type Retrier struct {
+	producer sarama.SyncProducer
+	policy   Policy
+}
+
+func NewRetrier(producer sarama.SyncProducer, policy Policy) *Retrier {
+	return &Retrier{producer: producer, policy: policy}
+}
+
+// maxPending is how many messages of a partition may wait for their retry
+// time at once. Beyond it the partition is not read until one is handled.
+const maxPending = 100
+
+// Consume handles the messages of a claim with handle, parking the ones that
+// fail through Fail. Messages taken from a retry topic are held in memory
+// until they are due, without blocking the claim: messages behind them that
+// are already due are handled meanwhile, and a timer re-checks the held ones.
+// An offset is marked only once it and every earlier offset of the claim are
+// handled, so a message still waiting when the claim ends is delivered again.
+func (r *Retrier) Consume(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim,
+	handle func(msg *sarama.ConsumerMessage) error) error {
+	var (
+		// unmarked are the messages read but not marked yet, in offset order.
+		unmarked []*sarama.ConsumerMessage
+		handled  = map[int64]bool{}
+		// pending are the messages not due yet.
+		pending []*sarama.ConsumerMessage
+	)
+
+	process := func(msg *sarama.ConsumerMessage) error {
+		if err := handle(msg); err != nil {
+			log.Printf("Failed to handle message from %s: %v", msg.Topic, err)
+			if err := r.Fail(msg, err); err != nil {
+				return err
+			}
+		}
+		handled[msg.Offset] = true
+		for len(unmarked) > 0 && handled[unmarked[0].Offset] {
+			session.MarkMessage(unmarked[0], "")
+			delete(handled, unmarked[0].Offset)
+			unmarked = unmarked[1:]
+		}
+		return nil
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for {
+		messages := claim.Messages()
+		if len(pending) >= maxPending {
+			messages = nil
+		}
+
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			unmarked = append(unmarked, msg)
+			if dueAt(msg).After(time.Now()) {
+				pending = append(pending, msg)
+			} else if err := process(msg); err != nil {
+				return err
+			}
+		case <-timer.C:
+		}
+
+		// Handle the held messages that are due and wake up for the next one.
+		var next time.Time
+		remaining := pending[:0]
+		for _, msg := range pending {
+			due := dueAt(msg)
+			if due.After(time.Now()) {
+				remaining = append(remaining, msg)
+				if next.IsZero() || due.Before(next) {
+					next = due
+				}
+				continue
+			}
+			if err := process(msg); err != nil {
+				return err
+			}
+		}
+		pending = remaining
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// dueAt returns when a message taken from a retry topic may be retried. Other
+// messages are due at once.
+func dueAt(msg *sarama.ConsumerMessage) time.Time {
+	retryAt, err := strconv.ParseInt(Header(msg, HeaderRetryAt), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(retryAt)
+}
+
+// Fail parks msg on the next retry topic, or on the dead-letter topic when
+// the policy is exhausted or the error is permanent.
+func (r *Retrier) Fail(msg *sarama.ConsumerMessage, cause error) error {
+	source := SourceTopic(msg)
+	attempt := attemptOf(msg) + 1
+
+	var target string
+	var retryAt time.Time
+	var perm *permanentError
+	if attempt <= len(r.policy.Delays) && !errors.As(cause, &perm) {
+		target = retryTopic(source, attempt)
+		retryAt = time.Now().Add(r.policy.Delays[attempt-1])
+	} else {
+		target = dlqTopic(source)
+	}
+
+	headers := passthroughHeaders(msg)
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderOriginalTopic), Value: []byte(source)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalPartition), Value: []byte(OriginalValue(msg, HeaderOriginalPartition, int64(msg.Partition)))},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalOffset), Value: []byte(OriginalValue(msg, HeaderOriginalOffset, msg.Offset))},
+		sarama.RecordHeader{Key: []byte(HeaderAttempt), Value: []byte(strconv.Itoa(attempt))},
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(cause.Error())},
+	)
+	if !retryAt.IsZero() {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderRetryAt), Value: []byte(strconv.FormatInt(retryAt.UnixMilli(), 10))})
+	}
+
+	_, _, err := r.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   target,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", target, err)
+	}
+
+	log.Printf("Message from %s (attempt %d) moved to %s: %v", source, attempt, target, cause)
+	return nil
+}
+
+// RedriveDLQ publishes everything currently parked on <topic>.dlq back to
+// <topic> with a fresh attempt count. Progress is committed under a consumer
+// group dedicated to group, so every dead-lettered message is re-driven only
+// once.
+func RedriveDLQ(brokers []string, group string, topic string) error {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create producer: %w", err)
+	}
+	defer producer.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	offsets, err := sarama.NewOffsetManagerFromClient(group+"-dlq-redrive", client)
+	if err != nil {
+		return fmt.Errorf("failed to create offset manager: %w", err)
+	}
+	defer offsets.Close()
+
+	dlq := dlqTopic(topic)
+	partitions, err := client.Partitions(dlq)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", dlq, err)
+	}
+
+	redriven := 0
+	for _, partition := range partitions {
+		n, err := redrivePartition(client, consumer, offsets, producer, dlq, partition)
+		redriven += n
+		if err != nil {
+			return err
+		}
+	}
+	offsets.Commit()
+
+	log.Printf("Re-drove %d message(s) from %s to %s", redriven, dlq, topic)
+	return nil
+}
+
+func redrivePartition(client sarama.Client, consumer sarama.Consumer, offsets sarama.OffsetManager,
+	producer sarama.SyncProducer, dlq string, partition int32) (int, error) {
+	newest, err := client.GetOffset(dlq, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset of %s/%d: %w", dlq, partition, err)
+	}
+
+	pom, err := offsets.ManagePartition(dlq, partition)
+	if err != nil {
+		return 0, fmt.Errorf("failed to manage offsets of %s/%d: %w", dlq, partition, err)
+	}
+	defer pom.Close()
+
+	next, _ := pom.NextOffset()
+	if next < 0 {
+		next = sarama.OffsetOldest
+	}
+	if next >= newest {
+		return 0, nil
+	}
+
+	pc, err := consumer.ConsumePartition(dlq, partition, next)
+	if err != nil {
+		return 0, fmt.Errorf("failed to consume %s/%d: %w", dlq, partition, err)
+	}
+	defer pc.Close()
+
+	redriven := 0
+	for msg := range pc.Messages() {
+		_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+			Topic:   SourceTopic(msg),
+			Key:     sarama.ByteEncoder(msg.Key),
+			Value:   sarama.ByteEncoder(msg.Value),
+			Headers: passthroughHeaders(msg),
+		})
+		if err != nil {
+			return redriven, fmt.Errorf("failed to re-drive message %s/%d/%d: %w", dlq, partition, msg.Offset, err)
+		}
+		pom.MarkOffset(msg.Offset+1, "")
+		redriven++
+
+		if msg.Offset+1 >= newest {
+			break
+		}
+	}
+	return redriven, nil
+}
+
+// SourceTopic returns the topic a message was originally published to,
+// following retry and dead-letter hops.
+func SourceTopic(msg *sarama.ConsumerMessage) string {
+	if topic := Header(msg, HeaderOriginalTopic); topic != "" {
+		return topic
+	}
+	return strings.TrimSuffix(msg.Topic, ".dlq")
+}
+
+func attemptOf(msg *sarama.ConsumerMessage) int {
+	attempt, err := strconv.Atoi(Header(msg, HeaderAttempt))
+	if err != nil {
+		return 0
+	}
+	return attempt
+}
+
+// OriginalValue returns a coordinate of the original message kept in header
+// key, or fallback if the message was never moved.
+func OriginalValue(msg *sarama.ConsumerMessage, key string, fallback int64) string {
+	if value := Header(msg, key); value != "" {
+		return value
+	}
+	return strconv.FormatInt(fallback, 10)
+}
+
+// Header returns the value of a message header, or "" if it is missing.
+func Header(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// passthroughHeaders keeps the producer's own headers (such as the event id)
+// and drops the retry bookkeeping.
+func passthroughHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	var headers []sarama.RecordHeader
+	for _, h := range msg.Headers {
+		if strings.HasPrefix(string(h.Key), "x-") {
+			continue
+		}
+		headers = append(headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return headers
+}
@@ -0,0 +1,110 @@
+package retry
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func retryMessage(offset int64, retryAt time.Time) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Topic: "orders.retry.1", Offset: offset}
+	if !retryAt.IsZero() {
+		msg.Headers = []*sarama.RecordHeader{
+			{Key: []byte(HeaderRetryAt), Value: []byte(strconv.FormatInt(retryAt.UnixMilli(), 10))},
+		}
+	}
+	return msg
+}
+
+func TestConsumeHandlesDueMessagesBehindAWaitingOne(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	claim.messages <- retryMessage(0, time.Now().Add(300*time.Millisecond))
+	claim.messages <- retryMessage(1, time.Time{})
+	claim.messages <- retryMessage(2, time.Time{})
+
+	var mu sync.Mutex
+	var order []int64
+	var markedBeforeFirst int
+	handle := func(msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, msg.Offset)
+		if msg.Offset == 0 {
+			session.mu.Lock()
+			markedBeforeFirst = len(session.marked)
+			session.mu.Unlock()
+		}
+		if len(order) == 3 {
+			close(claim.messages)
+		}
+		return nil
+	}
+
+	r := NewRetrier(nil, DefaultPolicy)
+	if err := r.Consume(session, claim, handle); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	if want := []int64{1, 2, 0}; !slices.Equal(order, want) {
+		t.Fatalf("handled %v, want %v", order, want)
+	}
+	if markedBeforeFirst != 0 {
+		t.Fatalf("%d offsets marked before offset 0 was handled", markedBeforeFirst)
+	}
+	if want := []int64{0, 1, 2}; !slices.Equal(session.marked, want) {
+		t.Fatalf("marked %v, want %v", session.marked, want)
+	}
+}
+
+func TestConsumeLeavesWaitingMessagesUnmarkedWhenTheSessionEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- retryMessage(0, time.Now().Add(time.Hour))
+	claim.messages <- retryMessage(1, time.Time{})
+
+	handle := func(msg *sarama.ConsumerMessage) error {
+		cancel()
+		return nil
+	}
+
+	r := NewRetrier(nil, DefaultPolicy)
+	if err := r.Consume(session, claim, handle); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if len(session.marked) != 0 {
+		t.Fatalf("marked %v, want nothing while offset 0 waits", session.marked)
+	}
+}
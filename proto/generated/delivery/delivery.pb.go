@@ -26,6 +26,8 @@ type CreateDeliveryRequest struct {
 	OrderId         string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
 	UserId          string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
 	DeliveryAddress string                 `protobuf:"bytes,3,opt,name=delivery_address,json=deliveryAddress,proto3" json:"delivery_address,omitempty"`
+	RecipientName   string                 `protobuf:"bytes,4,opt,name=recipient_name,json=recipientName,proto3" json:"recipient_name,omitempty"`
+	RecipientPhone  string                 `protobuf:"bytes,5,opt,name=recipient_phone,json=recipientPhone,proto3" json:"recipient_phone,omitempty"`
 	unknownFields   protoimpl.UnknownFields
 	sizeCache       protoimpl.SizeCache
 }
@@ -81,6 +83,20 @@ func (x *CreateDeliveryRequest) GetDeliveryAddress() string {
 	return ""
 }
 
+func (x *CreateDeliveryRequest) GetRecipientName() string {
+	if x != nil {
+		return x.RecipientName
+	}
+	return ""
+}
+
+func (x *CreateDeliveryRequest) GetRecipientPhone() string {
+	if x != nil {
+		return x.RecipientPhone
+	}
+	return ""
+}
+
 type CreateDeliveryResponse struct {
 	state             protoimpl.MessageState `protogen:"open.v1"`
 	DeliveryId        string                 `protobuf:"bytes,1,opt,name=delivery_id,json=deliveryId,proto3" json:"delivery_id,omitempty"`
@@ -201,6 +217,9 @@ type GetDeliveryStatusResponse struct {
 	TrackingNumber    string                 `protobuf:"bytes,4,opt,name=tracking_number,json=trackingNumber,proto3" json:"tracking_number,omitempty"`
 	EstimatedDelivery string                 `protobuf:"bytes,5,opt,name=estimated_delivery,json=estimatedDelivery,proto3" json:"estimated_delivery,omitempty"`
 	CurrentLocation   string                 `protobuf:"bytes,6,opt,name=current_location,json=currentLocation,proto3" json:"current_location,omitempty"`
+	DeliveryAddress   string                 `protobuf:"bytes,7,opt,name=delivery_address,json=deliveryAddress,proto3" json:"delivery_address,omitempty"`
+	RecipientName     string                 `protobuf:"bytes,8,opt,name=recipient_name,json=recipientName,proto3" json:"recipient_name,omitempty"`
+	CourierId         string                 `protobuf:"bytes,9,opt,name=courier_id,json=courierId,proto3" json:"courier_id,omitempty"` // empty while waiting for a courier
 	unknownFields     protoimpl.UnknownFields
 	sizeCache         protoimpl.SizeCache
 }
@@ -277,15 +296,612 @@ func (x *GetDeliveryStatusResponse) GetCurrentLocation() string {
 	return ""
 }
 
+func (x *GetDeliveryStatusResponse) GetDeliveryAddress() string {
+	if x != nil {
+		return x.DeliveryAddress
+	}
+	return ""
+}
+
+func (x *GetDeliveryStatusResponse) GetRecipientName() string {
+	if x != nil {
+		return x.RecipientName
+	}
+	return ""
+}
+
+func (x *GetDeliveryStatusResponse) GetCourierId() string {
+	if x != nil {
+		return x.CourierId
+	}
+	return ""
+}
+
+type GetTrackingHistoryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OrderId       string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrackingHistoryRequest) Reset() {
+	*x = GetTrackingHistoryRequest{}
+	mi := &file_proto_delivery_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackingHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackingHistoryRequest) ProtoMessage() {}
+
+func (x *GetTrackingHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackingHistoryRequest.ProtoReflect.Descriptor instead.
+func (*GetTrackingHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetTrackingHistoryRequest) GetOrderId() string {
+	if x != nil {
+		return x.OrderId
+	}
+	return ""
+}
+
+type TrackingCheckpoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Location      string                 `protobuf:"bytes,2,opt,name=location,proto3" json:"location,omitempty"`
+	Timestamp     string                 `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // RFC3339
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrackingCheckpoint) Reset() {
+	*x = TrackingCheckpoint{}
+	mi := &file_proto_delivery_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackingCheckpoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackingCheckpoint) ProtoMessage() {}
+
+func (x *TrackingCheckpoint) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackingCheckpoint.ProtoReflect.Descriptor instead.
+func (*TrackingCheckpoint) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TrackingCheckpoint) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *TrackingCheckpoint) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+func (x *TrackingCheckpoint) GetTimestamp() string {
+	if x != nil {
+		return x.Timestamp
+	}
+	return ""
+}
+
+type GetTrackingHistoryResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	OrderId        string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	DeliveryId     string                 `protobuf:"bytes,2,opt,name=delivery_id,json=deliveryId,proto3" json:"delivery_id,omitempty"`
+	TrackingNumber string                 `protobuf:"bytes,3,opt,name=tracking_number,json=trackingNumber,proto3" json:"tracking_number,omitempty"`
+	Status         string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	Checkpoints    []*TrackingCheckpoint  `protobuf:"bytes,5,rep,name=checkpoints,proto3" json:"checkpoints,omitempty"` // oldest first
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetTrackingHistoryResponse) Reset() {
+	*x = GetTrackingHistoryResponse{}
+	mi := &file_proto_delivery_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackingHistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackingHistoryResponse) ProtoMessage() {}
+
+func (x *GetTrackingHistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackingHistoryResponse.ProtoReflect.Descriptor instead.
+func (*GetTrackingHistoryResponse) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetTrackingHistoryResponse) GetOrderId() string {
+	if x != nil {
+		return x.OrderId
+	}
+	return ""
+}
+
+func (x *GetTrackingHistoryResponse) GetDeliveryId() string {
+	if x != nil {
+		return x.DeliveryId
+	}
+	return ""
+}
+
+func (x *GetTrackingHistoryResponse) GetTrackingNumber() string {
+	if x != nil {
+		return x.TrackingNumber
+	}
+	return ""
+}
+
+func (x *GetTrackingHistoryResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *GetTrackingHistoryResponse) GetCheckpoints() []*TrackingCheckpoint {
+	if x != nil {
+		return x.Checkpoints
+	}
+	return nil
+}
+
+type TrackParcelRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	TrackingNumber string                 `protobuf:"bytes,1,opt,name=tracking_number,json=trackingNumber,proto3" json:"tracking_number,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *TrackParcelRequest) Reset() {
+	*x = TrackParcelRequest{}
+	mi := &file_proto_delivery_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackParcelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackParcelRequest) ProtoMessage() {}
+
+func (x *TrackParcelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackParcelRequest.ProtoReflect.Descriptor instead.
+func (*TrackParcelRequest) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TrackParcelRequest) GetTrackingNumber() string {
+	if x != nil {
+		return x.TrackingNumber
+	}
+	return ""
+}
+
+// TrackParcelResponse is the public view of a delivery: it carries nothing
+// that identifies the order, the user or the recipient.
+type TrackParcelResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	TrackingNumber    string                 `protobuf:"bytes,1,opt,name=tracking_number,json=trackingNumber,proto3" json:"tracking_number,omitempty"`
+	Status            string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	CurrentLocation   string                 `protobuf:"bytes,3,opt,name=current_location,json=currentLocation,proto3" json:"current_location,omitempty"`
+	EstimatedDelivery string                 `protobuf:"bytes,4,opt,name=estimated_delivery,json=estimatedDelivery,proto3" json:"estimated_delivery,omitempty"`
+	Checkpoints       []*TrackingCheckpoint  `protobuf:"bytes,5,rep,name=checkpoints,proto3" json:"checkpoints,omitempty"` // oldest first
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *TrackParcelResponse) Reset() {
+	*x = TrackParcelResponse{}
+	mi := &file_proto_delivery_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackParcelResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackParcelResponse) ProtoMessage() {}
+
+func (x *TrackParcelResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackParcelResponse.ProtoReflect.Descriptor instead.
+func (*TrackParcelResponse) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TrackParcelResponse) GetTrackingNumber() string {
+	if x != nil {
+		return x.TrackingNumber
+	}
+	return ""
+}
+
+func (x *TrackParcelResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *TrackParcelResponse) GetCurrentLocation() string {
+	if x != nil {
+		return x.CurrentLocation
+	}
+	return ""
+}
+
+func (x *TrackParcelResponse) GetEstimatedDelivery() string {
+	if x != nil {
+		return x.EstimatedDelivery
+	}
+	return ""
+}
+
+func (x *TrackParcelResponse) GetCheckpoints() []*TrackingCheckpoint {
+	if x != nil {
+		return x.Checkpoints
+	}
+	return nil
+}
+
+type RegisterCourierRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Phone         string                 `protobuf:"bytes,2,opt,name=phone,proto3" json:"phone,omitempty"`
+	Zone          string                 `protobuf:"bytes,3,opt,name=zone,proto3" json:"zone,omitempty"`
+	Capacity      int32                  `protobuf:"varint,4,opt,name=capacity,proto3" json:"capacity,omitempty"` // parcels carried at once
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterCourierRequest) Reset() {
+	*x = RegisterCourierRequest{}
+	mi := &file_proto_delivery_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterCourierRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterCourierRequest) ProtoMessage() {}
+
+func (x *RegisterCourierRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterCourierRequest.ProtoReflect.Descriptor instead.
+func (*RegisterCourierRequest) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RegisterCourierRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *RegisterCourierRequest) GetPhone() string {
+	if x != nil {
+		return x.Phone
+	}
+	return ""
+}
+
+func (x *RegisterCourierRequest) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *RegisterCourierRequest) GetCapacity() int32 {
+	if x != nil {
+		return x.Capacity
+	}
+	return 0
+}
+
+type Courier struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	CourierId        string                 `protobuf:"bytes,1,opt,name=courier_id,json=courierId,proto3" json:"courier_id,omitempty"`
+	Name             string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Phone            string                 `protobuf:"bytes,3,opt,name=phone,proto3" json:"phone,omitempty"`
+	Zone             string                 `protobuf:"bytes,4,opt,name=zone,proto3" json:"zone,omitempty"`
+	Capacity         int32                  `protobuf:"varint,5,opt,name=capacity,proto3" json:"capacity,omitempty"`
+	OnShift          bool                   `protobuf:"varint,6,opt,name=on_shift,json=onShift,proto3" json:"on_shift,omitempty"`
+	ActiveDeliveries int32                  `protobuf:"varint,7,opt,name=active_deliveries,json=activeDeliveries,proto3" json:"active_deliveries,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Courier) Reset() {
+	*x = Courier{}
+	mi := &file_proto_delivery_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Courier) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Courier) ProtoMessage() {}
+
+func (x *Courier) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Courier.ProtoReflect.Descriptor instead.
+func (*Courier) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Courier) GetCourierId() string {
+	if x != nil {
+		return x.CourierId
+	}
+	return ""
+}
+
+func (x *Courier) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Courier) GetPhone() string {
+	if x != nil {
+		return x.Phone
+	}
+	return ""
+}
+
+func (x *Courier) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *Courier) GetCapacity() int32 {
+	if x != nil {
+		return x.Capacity
+	}
+	return 0
+}
+
+func (x *Courier) GetOnShift() bool {
+	if x != nil {
+		return x.OnShift
+	}
+	return false
+}
+
+func (x *Courier) GetActiveDeliveries() int32 {
+	if x != nil {
+		return x.ActiveDeliveries
+	}
+	return 0
+}
+
+type SetCourierShiftRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CourierId     string                 `protobuf:"bytes,1,opt,name=courier_id,json=courierId,proto3" json:"courier_id,omitempty"`
+	OnShift       bool                   `protobuf:"varint,2,opt,name=on_shift,json=onShift,proto3" json:"on_shift,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetCourierShiftRequest) Reset() {
+	*x = SetCourierShiftRequest{}
+	mi := &file_proto_delivery_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetCourierShiftRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetCourierShiftRequest) ProtoMessage() {}
+
+func (x *SetCourierShiftRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetCourierShiftRequest.ProtoReflect.Descriptor instead.
+func (*SetCourierShiftRequest) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SetCourierShiftRequest) GetCourierId() string {
+	if x != nil {
+		return x.CourierId
+	}
+	return ""
+}
+
+func (x *SetCourierShiftRequest) GetOnShift() bool {
+	if x != nil {
+		return x.OnShift
+	}
+	return false
+}
+
+type SetCourierShiftResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Courier       *Courier               `protobuf:"bytes,1,opt,name=courier,proto3" json:"courier,omitempty"`
+	Assigned      int32                  `protobuf:"varint,2,opt,name=assigned,proto3" json:"assigned,omitempty"` // deliveries given to a courier by the change
+	Waiting       int32                  `protobuf:"varint,3,opt,name=waiting,proto3" json:"waiting,omitempty"`   // deliveries still waiting for a courier
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetCourierShiftResponse) Reset() {
+	*x = SetCourierShiftResponse{}
+	mi := &file_proto_delivery_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetCourierShiftResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetCourierShiftResponse) ProtoMessage() {}
+
+func (x *SetCourierShiftResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_delivery_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetCourierShiftResponse.ProtoReflect.Descriptor instead.
+func (*SetCourierShiftResponse) Descriptor() ([]byte, []int) {
+	return file_proto_delivery_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SetCourierShiftResponse) GetCourier() *Courier {
+	if x != nil {
+		return x.Courier
+	}
+	return nil
+}
+
+func (x *SetCourierShiftResponse) GetAssigned() int32 {
+	if x != nil {
+		return x.Assigned
+	}
+	return 0
+}
+
+func (x *SetCourierShiftResponse) GetWaiting() int32 {
+	if x != nil {
+		return x.Waiting
+	}
+	return 0
+}
+
 var File_proto_delivery_proto protoreflect.FileDescriptor
 
 const file_proto_delivery_proto_rawDesc = "" +
 	"\n" +
-	"\x14proto/delivery.proto\x12\bdelivery\"v\n" +
+	"\x14proto/delivery.proto\x12\bdelivery\"\xc6\x01\n" +
 	"\x15CreateDeliveryRequest\x12\x19\n" +
 	"\border_id\x18\x01 \x01(\tR\aorderId\x12\x17\n" +
 	"\auser_id\x18\x02 \x01(\tR\x06userId\x12)\n" +
-	"\x10delivery_address\x18\x03 \x01(\tR\x0fdeliveryAddress\"\xa9\x01\n" +
+	"\x10delivery_address\x18\x03 \x01(\tR\x0fdeliveryAddress\x12%\n" +
+	"\x0erecipient_name\x18\x04 \x01(\tR\rrecipientName\x12'\n" +
+	"\x0frecipient_phone\x18\x05 \x01(\tR\x0erecipientPhone\"\xa9\x01\n" +
 	"\x16CreateDeliveryResponse\x12\x1f\n" +
 	"\vdelivery_id\x18\x01 \x01(\tR\n" +
 	"deliveryId\x12'\n" +
@@ -293,7 +909,7 @@ const file_proto_delivery_proto_rawDesc = "" +
 	"\x06status\x18\x03 \x01(\tR\x06status\x12-\n" +
 	"\x12estimated_delivery\x18\x04 \x01(\tR\x11estimatedDelivery\"5\n" +
 	"\x18GetDeliveryStatusRequest\x12\x19\n" +
-	"\border_id\x18\x01 \x01(\tR\aorderId\"\xf2\x01\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\"\xe3\x02\n" +
 	"\x19GetDeliveryStatusResponse\x12\x19\n" +
 	"\border_id\x18\x01 \x01(\tR\aorderId\x12\x1f\n" +
 	"\vdelivery_id\x18\x02 \x01(\tR\n" +
@@ -301,10 +917,61 @@ const file_proto_delivery_proto_rawDesc = "" +
 	"\x06status\x18\x03 \x01(\tR\x06status\x12'\n" +
 	"\x0ftracking_number\x18\x04 \x01(\tR\x0etrackingNumber\x12-\n" +
 	"\x12estimated_delivery\x18\x05 \x01(\tR\x11estimatedDelivery\x12)\n" +
-	"\x10current_location\x18\x06 \x01(\tR\x0fcurrentLocation2\xc4\x01\n" +
+	"\x10current_location\x18\x06 \x01(\tR\x0fcurrentLocation\x12)\n" +
+	"\x10delivery_address\x18\a \x01(\tR\x0fdeliveryAddress\x12%\n" +
+	"\x0erecipient_name\x18\b \x01(\tR\rrecipientName\x12\x1d\n" +
+	"\n" +
+	"courier_id\x18\t \x01(\tR\tcourierId\"6\n" +
+	"\x19GetTrackingHistoryRequest\x12\x19\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\"f\n" +
+	"\x12TrackingCheckpoint\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1a\n" +
+	"\blocation\x18\x02 \x01(\tR\blocation\x12\x1c\n" +
+	"\ttimestamp\x18\x03 \x01(\tR\ttimestamp\"\xd9\x01\n" +
+	"\x1aGetTrackingHistoryResponse\x12\x19\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\x12\x1f\n" +
+	"\vdelivery_id\x18\x02 \x01(\tR\n" +
+	"deliveryId\x12'\n" +
+	"\x0ftracking_number\x18\x03 \x01(\tR\x0etrackingNumber\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12>\n" +
+	"\vcheckpoints\x18\x05 \x03(\v2\x1c.delivery.TrackingCheckpointR\vcheckpoints\"=\n" +
+	"\x12TrackParcelRequest\x12'\n" +
+	"\x0ftracking_number\x18\x01 \x01(\tR\x0etrackingNumber\"\xf0\x01\n" +
+	"\x13TrackParcelResponse\x12'\n" +
+	"\x0ftracking_number\x18\x01 \x01(\tR\x0etrackingNumber\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12)\n" +
+	"\x10current_location\x18\x03 \x01(\tR\x0fcurrentLocation\x12-\n" +
+	"\x12estimated_delivery\x18\x04 \x01(\tR\x11estimatedDelivery\x12>\n" +
+	"\vcheckpoints\x18\x05 \x03(\v2\x1c.delivery.TrackingCheckpointR\vcheckpoints\"r\n" +
+	"\x16RegisterCourierRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05phone\x18\x02 \x01(\tR\x05phone\x12\x12\n" +
+	"\x04zone\x18\x03 \x01(\tR\x04zone\x12\x1a\n" +
+	"\bcapacity\x18\x04 \x01(\x05R\bcapacity\"\xca\x01\n" +
+	"\aCourier\x12\x1d\n" +
+	"\n" +
+	"courier_id\x18\x01 \x01(\tR\tcourierId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05phone\x18\x03 \x01(\tR\x05phone\x12\x12\n" +
+	"\x04zone\x18\x04 \x01(\tR\x04zone\x12\x1a\n" +
+	"\bcapacity\x18\x05 \x01(\x05R\bcapacity\x12\x19\n" +
+	"\bon_shift\x18\x06 \x01(\bR\aonShift\x12+\n" +
+	"\x11active_deliveries\x18\a \x01(\x05R\x10activeDeliveries\"R\n" +
+	"\x16SetCourierShiftRequest\x12\x1d\n" +
+	"\n" +
+	"courier_id\x18\x01 \x01(\tR\tcourierId\x12\x19\n" +
+	"\bon_shift\x18\x02 \x01(\bR\aonShift\"|\n" +
+	"\x17SetCourierShiftResponse\x12+\n" +
+	"\acourier\x18\x01 \x01(\v2\x11.delivery.CourierR\acourier\x12\x1a\n" +
+	"\bassigned\x18\x02 \x01(\x05R\bassigned\x12\x18\n" +
+	"\awaiting\x18\x03 \x01(\x05R\awaiting2\x91\x04\n" +
 	"\x0fDeliveryService\x12S\n" +
 	"\x0eCreateDelivery\x12\x1f.delivery.CreateDeliveryRequest\x1a .delivery.CreateDeliveryResponse\x12\\\n" +
-	"\x11GetDeliveryStatus\x12\".delivery.GetDeliveryStatusRequest\x1a#.delivery.GetDeliveryStatusResponseB\x1fZ\x1dmicro-delivery/proto/deliveryb\x06proto3"
+	"\x11GetDeliveryStatus\x12\".delivery.GetDeliveryStatusRequest\x1a#.delivery.GetDeliveryStatusResponse\x12_\n" +
+	"\x12GetTrackingHistory\x12#.delivery.GetTrackingHistoryRequest\x1a$.delivery.GetTrackingHistoryResponse\x12J\n" +
+	"\vTrackParcel\x12\x1c.delivery.TrackParcelRequest\x1a\x1d.delivery.TrackParcelResponse\x12F\n" +
+	"\x0fRegisterCourier\x12 .delivery.RegisterCourierRequest\x1a\x11.delivery.Courier\x12V\n" +
+	"\x0fSetCourierShift\x12 .delivery.SetCourierShiftRequest\x1a!.delivery.SetCourierShiftResponseB\x1fZ\x1dmicro-delivery/proto/deliveryb\x06proto3"
 
 var (
 	file_proto_delivery_proto_rawDescOnce sync.Once
@@ -318,23 +985,43 @@ func file_proto_delivery_proto_rawDescGZIP() []byte {
 	return file_proto_delivery_proto_rawDescData
 }
 
-var file_proto_delivery_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_delivery_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_proto_delivery_proto_goTypes = []any{
-	(*CreateDeliveryRequest)(nil),     // 0: delivery.CreateDeliveryRequest
-	(*CreateDeliveryResponse)(nil),    // 1: delivery.CreateDeliveryResponse
-	(*GetDeliveryStatusRequest)(nil),  // 2: delivery.GetDeliveryStatusRequest
-	(*GetDeliveryStatusResponse)(nil), // 3: delivery.GetDeliveryStatusResponse
+	(*CreateDeliveryRequest)(nil),      // 0: delivery.CreateDeliveryRequest
+	(*CreateDeliveryResponse)(nil),     // 1: delivery.CreateDeliveryResponse
+	(*GetDeliveryStatusRequest)(nil),   // 2: delivery.GetDeliveryStatusRequest
+	(*GetDeliveryStatusResponse)(nil),  // 3: delivery.GetDeliveryStatusResponse
+	(*GetTrackingHistoryRequest)(nil),  // 4: delivery.GetTrackingHistoryRequest
+	(*TrackingCheckpoint)(nil),         // 5: delivery.TrackingCheckpoint
+	(*GetTrackingHistoryResponse)(nil), // 6: delivery.GetTrackingHistoryResponse
+	(*TrackParcelRequest)(nil),         // 7: delivery.TrackParcelRequest
+	(*TrackParcelResponse)(nil),        // 8: delivery.TrackParcelResponse
+	(*RegisterCourierRequest)(nil),     // 9: delivery.RegisterCourierRequest
+	(*Courier)(nil),                    // 10: delivery.Courier
+	(*SetCourierShiftRequest)(nil),     // 11: delivery.SetCourierShiftRequest
+	(*SetCourierShiftResponse)(nil),    // 12: delivery.SetCourierShiftResponse
 }
 var file_proto_delivery_proto_depIdxs = []int32{
-	0, // 0: delivery.DeliveryService.CreateDelivery:input_type -> delivery.CreateDeliveryRequest
-	2, // 1: delivery.DeliveryService.GetDeliveryStatus:input_type -> delivery.GetDeliveryStatusRequest
-	1, // 2: delivery.DeliveryService.CreateDelivery:output_type -> delivery.CreateDeliveryResponse
-	3, // 3: delivery.DeliveryService.GetDeliveryStatus:output_type -> delivery.GetDeliveryStatusResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	5,  // 0: delivery.GetTrackingHistoryResponse.checkpoints:type_name -> delivery.TrackingCheckpoint
+	5,  // 1: delivery.TrackParcelResponse.checkpoints:type_name -> delivery.TrackingCheckpoint
+	10, // 2: delivery.SetCourierShiftResponse.courier:type_name -> delivery.Courier
+	0,  // 3: delivery.DeliveryService.CreateDelivery:input_type -> delivery.CreateDeliveryRequest
+	2,  // 4: delivery.DeliveryService.GetDeliveryStatus:input_type -> delivery.GetDeliveryStatusRequest
+	4,  // 5: delivery.DeliveryService.GetTrackingHistory:input_type -> delivery.GetTrackingHistoryRequest
+	7,  // 6: delivery.DeliveryService.TrackParcel:input_type -> delivery.TrackParcelRequest
+	9,  // 7: delivery.DeliveryService.RegisterCourier:input_type -> delivery.RegisterCourierRequest
+	11, // 8: delivery.DeliveryService.SetCourierShift:input_type -> delivery.SetCourierShiftRequest
+	1,  // 9: delivery.DeliveryService.CreateDelivery:output_type -> delivery.CreateDeliveryResponse
+	3,  // 10: delivery.DeliveryService.GetDeliveryStatus:output_type -> delivery.GetDeliveryStatusResponse
+	6,  // 11: delivery.DeliveryService.GetTrackingHistory:output_type -> delivery.GetTrackingHistoryResponse
+	8,  // 12: delivery.DeliveryService.TrackParcel:output_type -> delivery.TrackParcelResponse
+	10, // 13: delivery.DeliveryService.RegisterCourier:output_type -> delivery.Courier
+	12, // 14: delivery.DeliveryService.SetCourierShift:output_type -> delivery.SetCourierShiftResponse
+	9,  // [9:15] is the sub-list for method output_type
+	3,  // [3:9] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_delivery_proto_init() }
@@ -348,7 +1035,7 @@ func file_proto_delivery_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_delivery_proto_rawDesc), len(file_proto_delivery_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   13,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,8 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	DeliveryService_CreateDelivery_FullMethodName    = "/delivery.DeliveryService/CreateDelivery"
-	DeliveryService_GetDeliveryStatus_FullMethodName = "/delivery.DeliveryService/GetDeliveryStatus"
+	DeliveryService_CreateDelivery_FullMethodName     = "/delivery.DeliveryService/CreateDelivery"
+	DeliveryService_GetDeliveryStatus_FullMethodName  = "/delivery.DeliveryService/GetDeliveryStatus"
+	DeliveryService_GetTrackingHistory_FullMethodName = "/delivery.DeliveryService/GetTrackingHistory"
+	DeliveryService_TrackParcel_FullMethodName        = "/delivery.DeliveryService/TrackParcel"
+	DeliveryService_RegisterCourier_FullMethodName    = "/delivery.DeliveryService/RegisterCourier"
+	DeliveryService_SetCourierShift_FullMethodName    = "/delivery.DeliveryService/SetCourierShift"
 )
 
 // DeliveryServiceClient is the client API for DeliveryService service.
@@ -29,6 +33,10 @@ const (
 type DeliveryServiceClient interface {
 	CreateDelivery(ctx context.Context, in *CreateDeliveryRequest, opts ...grpc.CallOption) (*CreateDeliveryResponse, error)
 	GetDeliveryStatus(ctx context.Context, in *GetDeliveryStatusRequest, opts ...grpc.CallOption) (*GetDeliveryStatusResponse, error)
+	GetTrackingHistory(ctx context.Context, in *GetTrackingHistoryRequest, opts ...grpc.CallOption) (*GetTrackingHistoryResponse, error)
+	TrackParcel(ctx context.Context, in *TrackParcelRequest, opts ...grpc.CallOption) (*TrackParcelResponse, error)
+	RegisterCourier(ctx context.Context, in *RegisterCourierRequest, opts ...grpc.CallOption) (*Courier, error)
+	SetCourierShift(ctx context.Context, in *SetCourierShiftRequest, opts ...grpc.CallOption) (*SetCourierShiftResponse, error)
 }
 
 type deliveryServiceClient struct {
@@ -59,12 +67,56 @@ func (c *deliveryServiceClient) GetDeliveryStatus(ctx context.Context, in *GetDe
 	return out, nil
 }
 
+func (c *deliveryServiceClient) GetTrackingHistory(ctx context.Context, in *GetTrackingHistoryRequest, opts ...grpc.CallOption) (*GetTrackingHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTrackingHistoryResponse)
+	err := c.cc.Invoke(ctx, DeliveryService_GetTrackingHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deliveryServiceClient) TrackParcel(ctx context.Context, in *TrackParcelRequest, opts ...grpc.CallOption) (*TrackParcelResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrackParcelResponse)
+	err := c.cc.Invoke(ctx, DeliveryService_TrackParcel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deliveryServiceClient) RegisterCourier(ctx context.Context, in *RegisterCourierRequest, opts ...grpc.CallOption) (*Courier, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Courier)
+	err := c.cc.Invoke(ctx, DeliveryService_RegisterCourier_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deliveryServiceClient) SetCourierShift(ctx context.Context, in *SetCourierShiftRequest, opts ...grpc.CallOption) (*SetCourierShiftResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetCourierShiftResponse)
+	err := c.cc.Invoke(ctx, DeliveryService_SetCourierShift_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DeliveryServiceServer is the server API for DeliveryService service.
 // All implementations must embed UnimplementedDeliveryServiceServer
 // for forward compatibility.
 type DeliveryServiceServer interface {
 	CreateDelivery(context.Context, *CreateDeliveryRequest) (*CreateDeliveryResponse, error)
 	GetDeliveryStatus(context.Context, *GetDeliveryStatusRequest) (*GetDeliveryStatusResponse, error)
+	GetTrackingHistory(context.Context, *GetTrackingHistoryRequest) (*GetTrackingHistoryResponse, error)
+	TrackParcel(context.Context, *TrackParcelRequest) (*TrackParcelResponse, error)
+	RegisterCourier(context.Context, *RegisterCourierRequest) (*Courier, error)
+	SetCourierShift(context.Context, *SetCourierShiftRequest) (*SetCourierShiftResponse, error)
 	mustEmbedUnimplementedDeliveryServiceServer()
 }
 
@@ -81,6 +133,18 @@ func (UnimplementedDeliveryServiceServer) CreateDelivery(context.Context, *Creat
 func (UnimplementedDeliveryServiceServer) GetDeliveryStatus(context.Context, *GetDeliveryStatusRequest) (*GetDeliveryStatusResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GetDeliveryStatus not implemented")
 }
+func (UnimplementedDeliveryServiceServer) GetTrackingHistory(context.Context, *GetTrackingHistoryRequest) (*GetTrackingHistoryResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTrackingHistory not implemented")
+}
+func (UnimplementedDeliveryServiceServer) TrackParcel(context.Context, *TrackParcelRequest) (*TrackParcelResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method TrackParcel not implemented")
+}
+func (UnimplementedDeliveryServiceServer) RegisterCourier(context.Context, *RegisterCourierRequest) (*Courier, error) {
+	return nil, status.Error(codes.Unimplemented, "method RegisterCourier not implemented")
+}
+func (UnimplementedDeliveryServiceServer) SetCourierShift(context.Context, *SetCourierShiftRequest) (*SetCourierShiftResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method SetCourierShift not implemented")
+}
 func (UnimplementedDeliveryServiceServer) mustEmbedUnimplementedDeliveryServiceServer() {}
 func (UnimplementedDeliveryServiceServer) testEmbeddedByValue()                         {}
 
@@ -138,6 +202,78 @@ func _DeliveryService_GetDeliveryStatus_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DeliveryService_GetTrackingHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTrackingHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeliveryServiceServer).GetTrackingHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeliveryService_GetTrackingHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeliveryServiceServer).GetTrackingHistory(ctx, req.(*GetTrackingHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeliveryService_TrackParcel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TrackParcelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeliveryServiceServer).TrackParcel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeliveryService_TrackParcel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeliveryServiceServer).TrackParcel(ctx, req.(*TrackParcelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeliveryService_RegisterCourier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterCourierRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeliveryServiceServer).RegisterCourier(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeliveryService_RegisterCourier_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeliveryServiceServer).RegisterCourier(ctx, req.(*RegisterCourierRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeliveryService_SetCourierShift_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetCourierShiftRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeliveryServiceServer).SetCourierShift(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeliveryService_SetCourierShift_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeliveryServiceServer).SetCourierShift(ctx, req.(*SetCourierShiftRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DeliveryService_ServiceDesc is the grpc.ServiceDesc for DeliveryService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -153,6 +289,22 @@ var DeliveryService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetDeliveryStatus",
 			Handler:    _DeliveryService_GetDeliveryStatus_Handler,
 		},
+		{
+			MethodName: "GetTrackingHistory",
+			Handler:    _DeliveryService_GetTrackingHistory_Handler,
+		},
+		{
+			MethodName: "TrackParcel",
+			Handler:    _DeliveryService_TrackParcel_Handler,
+		},
+		{
+			MethodName: "RegisterCourier",
+			Handler:    _DeliveryService_RegisterCourier_Handler,
+		},
+		{
+			MethodName: "SetCourierShift",
+			Handler:    _DeliveryService_SetCourierShift_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/delivery.proto",